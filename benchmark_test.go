@@ -27,7 +27,7 @@ func BenchmarkContextSelectorMap(b *testing.B) {
 	nested := d{"foo": d{"bar": d{"baz": "baz"}}}
 	c := newContext()
 	c.stack = pathRootedAt(nested)
-	sel := &selectorValue{0, false, []string{"foo", "bar", "baz"}}
+	sel := &selectorValue{0, false, []string{"foo", "bar", "baz"}, 0, 0}
 	for i := 0; i < b.N; i++ {
 		c.valueFor(sel)
 	}
@@ -42,7 +42,7 @@ func BenchmarkContextSelectorStruct(b *testing.B) {
 	nested := Item{Foo{Bar{Baz("baz")}}}
 	c := newContext()
 	c.stack = pathRootedAt(nested)
-	sel := &selectorValue{0, false, []string{"Foo", "Bar", "Baz"}}
+	sel := &selectorValue{0, false, []string{"Foo", "Bar", "Baz"}, 0, 0}
 	for i := 0; i < b.N; i++ {
 		c.valueFor(sel)
 	}
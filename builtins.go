@@ -0,0 +1,174 @@
+package tmpl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+//builtinFuncs are the functions available to every template unless they are
+//restricted with Template.Builtins or replaced with Template.Call.
+var builtinFuncs = map[string]interface{}{
+	"len":      builtinLen,
+	"eq":       builtinEq,
+	"not":      builtinNot,
+	"and":      builtinAnd,
+	"or":       builtinOr,
+	"join":     builtinJoin,
+	"upper":    builtinUpper,
+	"lower":    builtinLower,
+	"printf":   fmt.Sprintf,
+	"urlquery": builtinURLQuery,
+	"json":     builtinJSON,
+	"slice":    builtinSlice,
+	"first":    builtinFirst,
+	"last":     builtinLast,
+}
+
+//builtins is the reflected version of builtinFuncs.
+var builtins = map[string]reflect.Value{}
+
+func init() {
+	for name, fnc := range builtinFuncs {
+		builtins[name] = reflect.ValueOf(fnc)
+	}
+}
+
+//sequence returns the indirected value of x if it is something that can be
+//indexed: an array, slice or string.
+func sequence(name string, x interface{}) (v reflect.Value, err error) {
+	v = indirect(reflect.ValueOf(x))
+	switch v.Kind() {
+	case reflect.Array, reflect.Slice, reflect.String:
+		return
+	}
+	return reflect.Value{}, fmt.Errorf("can't %s a %T", name, x)
+}
+
+func builtinLen(x interface{}) (int, error) {
+	v := indirect(reflect.ValueOf(x))
+	switch v.Kind() {
+	case reflect.Array, reflect.Chan, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len(), nil
+	}
+	return 0, fmt.Errorf("can't take the len of a %T", x)
+}
+
+func builtinEq(x, y interface{}, rest ...interface{}) bool {
+	if equal(x, y) {
+		return true
+	}
+	for _, r := range rest {
+		if equal(x, r) {
+			return true
+		}
+	}
+	return false
+}
+
+func builtinNot(x interface{}) bool {
+	return !truthy(x)
+}
+
+func builtinAnd(x interface{}, rest ...interface{}) interface{} {
+	for _, r := range rest {
+		if !truthy(x) {
+			break
+		}
+		x = r
+	}
+	return x
+}
+
+func builtinOr(x interface{}, rest ...interface{}) interface{} {
+	for _, r := range rest {
+		if truthy(x) {
+			break
+		}
+		x = r
+	}
+	return x
+}
+
+func builtinJoin(x interface{}, sep string) (string, error) {
+	v := indirect(reflect.ValueOf(x))
+	switch v.Kind() {
+	case reflect.Array, reflect.Slice:
+	default:
+		return "", fmt.Errorf("can't join a %T", x)
+	}
+	items := make([]string, v.Len())
+	for i := range items {
+		items[i] = fmt.Sprint(v.Index(i).Interface())
+	}
+	return strings.Join(items, sep), nil
+}
+
+func builtinUpper(x interface{}) string {
+	return strings.ToUpper(fmt.Sprint(x))
+}
+
+func builtinLower(x interface{}) string {
+	return strings.ToLower(fmt.Sprint(x))
+}
+
+func builtinURLQuery(xs ...interface{}) string {
+	return url.QueryEscape(fmt.Sprint(xs...))
+}
+
+func builtinJSON(x interface{}) (string, error) {
+	data, err := json.Marshal(x)
+	return string(data), err
+}
+
+func builtinSlice(x interface{}, indices ...int) (interface{}, error) {
+	v, err := sequence("slice", x)
+	if err != nil {
+		return nil, err
+	}
+	i, j := 0, v.Len()
+	switch len(indices) {
+	case 0:
+	case 1:
+		i = indices[0]
+	case 2:
+		i, j = indices[0], indices[1]
+	default:
+		return nil, fmt.Errorf("too many indices: %d", len(indices))
+	}
+	if i < 0 || j < i || j > v.Len() {
+		return nil, fmt.Errorf("slice bounds [%d:%d] out of range with length %d", i, j, v.Len())
+	}
+	return v.Slice(i, j).Interface(), nil
+}
+
+func builtinFirst(x interface{}) (interface{}, error) {
+	v, err := sequence("take the first of", x)
+	switch {
+	case err != nil:
+		return nil, err
+	case v.Len() == 0:
+		return nil, fmt.Errorf("first of an empty %T", x)
+	case v.Kind() == reflect.String:
+		r, _ := utf8.DecodeRuneInString(v.String())
+		return string(r), nil
+	}
+	return v.Index(0).Interface(), nil
+}
+
+func builtinLast(x interface{}) (interface{}, error) {
+	v, err := sequence("take the last of", x)
+	switch {
+	case err != nil:
+		return nil, err
+	case v.Len() == 0:
+		return nil, fmt.Errorf("last of an empty %T", x)
+	case v.Kind() == reflect.String:
+		r, _ := utf8.DecodeLastRuneInString(v.String())
+		return string(r), nil
+	}
+	return v.Index(v.Len() - 1).Interface(), nil
+}
@@ -0,0 +1,108 @@
+package tmpl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuiltinsPass(t *testing.T) {
+	type user struct{ Name string }
+	executeTemplatePasses(t, []templatePassCase{
+		{`{% call len . %}`, []int{1, 2, 3}, `3`},
+		{`{% call len . %}`, "four", `4`},
+		{`{% call len . %}`, d{"a": 1}, `1`},
+		{`{% call len . %}`, &[]int{1}, `1`},
+		{`{% call eq .a .b %}`, d{"a": 1, "b": 1.0}, `true`},
+		{`{% call eq .a .b %}`, d{"a": uint8(1), "b": int64(1)}, `true`},
+		{`{% call eq .a "paid" %}`, d{"a": "paid"}, `true`},
+		{`{% call eq .a "paid" "settled" %}`, d{"a": "settled"}, `true`},
+		{`{% call eq .a "paid" "settled" %}`, d{"a": "refunded"}, `false`},
+		{`{% call eq .a .b %}`, d{"a": nil, "b": nil}, `true`},
+		{`{% call eq .a .b %}`, d{"a": user{"bob"}, "b": user{"bob"}}, `true`},
+		{`{% call eq .a 1 %}`, d{"a": -1}, `false`},
+		{`{% call not . %}`, false, `true`},
+		{`{% call not . %}`, "x", `false`},
+		{`{% call and .a .b %}`, d{"a": 1, "b": 2}, `2`},
+		{`{% call and .a .b %}`, d{"a": 0, "b": 2}, `0`},
+		{`{% call or .a .b %}`, d{"a": "", "b": "def"}, `def`},
+		{`{% call or .a .b %}`, d{"a": "x", "b": "def"}, `x`},
+		{`{% call join . ", " %}`, []string{"a", "b"}, `a, b`},
+		{`{% call join . "-" %}`, []int{1, 2, 3}, `1-2-3`},
+		{`{% call upper . %}`, "MiXed", `MIXED`},
+		{`{% call lower . %}`, "MiXed", `mixed`},
+		{`{% call printf "%s=%d" .k .v %}`, d{"k": "a", "v": 1}, `a=1`},
+		{`{% call urlquery . %}`, "a b&c", `a+b%26c`},
+		{`{% call json . %}`, d{"a": []int{1}}, `{"a":[1]}`},
+		{`{% call slice . 1 %}`, []int{1, 2, 3}, `[2 3]`},
+		{`{% call slice . 1 2 %}`, "abc", `b`},
+		{`{% call slice . %}`, []int{1, 2}, `[1 2]`},
+		{`{% call first . %}`, []string{"a", "b"}, `a`},
+		{`{% call first . %}`, "héllo", `h`},
+		{`{% call last . %}`, [2]int{1, 2}, `2`},
+		{`{% call last . %}`, "héllo", `o`},
+		{`{% if call eq .a "x" %}yes{% else %}no{% end if %}`, d{"a": "x"}, `yes`},
+	})
+}
+
+func TestBuiltinsFail(t *testing.T) {
+	executeTemplateFails(t, []templateFailCase{
+		{`{% call len . %}`, 5},
+		{`{% call eq . %}`, 5},
+		{`{% call join . "," %}`, "abc"},
+		{`{% call slice . 2 1 %}`, []int{1, 2, 3}},
+		{`{% call slice . 0 4 %}`, []int{1, 2, 3}},
+		{`{% call slice . 1 2 3 %}`, []int{1, 2, 3}},
+		{`{% call first . %}`, []int{}},
+		{`{% call last . %}`, d{}},
+		{`{% call json . %}`, func() {}},
+	})
+}
+
+func TestBuiltinsTemplate(t *testing.T) {
+	dir := createTestDir(t, []templateFile{
+		{"upper.tmpl", `{% call upper . %}`},
+		{"lower.tmpl", `{% call lower . %}`},
+	})
+	defer os.RemoveAll(dir)
+
+	j := func(path string) string {
+		return filepath.Join(dir, path)
+	}
+
+	cases := []struct {
+		tmpl *Template
+		exp  string
+		fail bool
+	}{
+		{Parse(j("upper.tmpl")), "FOO", false},
+		{Parse(j("upper.tmpl")).Builtins("upper"), "FOO", false},
+		{Parse(j("lower.tmpl")).Builtins("upper"), "", true},
+		{Parse(j("upper.tmpl")).Builtins(), "", true},
+		{Parse(j("upper.tmpl")).Call("upper", func(x string) string { return x + "!" }), "foo!", false},
+		{Parse(j("upper.tmpl")).Builtins().Call("upper", func(x string) string { return x + "?" }), "foo?", false},
+	}
+
+	for id, c := range cases {
+		var buf bytes.Buffer
+		err := c.tmpl.Execute(&buf, "foo")
+		switch {
+		case c.fail && err == nil:
+			t.Errorf("%d: Expected an error", id)
+		case !c.fail && err != nil:
+			t.Errorf("%d: %v", id, err)
+		case buf.String() != c.exp:
+			t.Errorf("%d:\nExp %q\nGot %q", id, c.exp, buf.String())
+		}
+	}
+}
+
+func TestBuiltinsUnknown(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Builtins didn't panic on an unknown name")
+		}
+	}()
+	Parse("base.tmpl").Builtins("upper", "flabdab")
+}
@@ -0,0 +1,389 @@
+package tmpl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//CheckError is a problem found in a template without executing it. Line and
+//Pos are where the problem is in File, counting from zero like the errors
+//from Execute.
+type CheckError struct {
+	File      string
+	Line, Pos int
+	Msg       string
+}
+
+func (e *CheckError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("%d:%d: %s", e.Line, e.Pos, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Pos, e.Msg)
+}
+
+//CheckErrors is every problem found in a template, ordered by where they are.
+type CheckErrors []*CheckError
+
+func (e CheckErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+//findings collects the problems found checking a template, along with the
+//file being checked.
+type findings struct {
+	file string
+	errs CheckErrors
+	dups map[string]bool
+}
+
+//errorf records a problem at the position in the current file, once.
+func (f *findings) errorf(line, pos int, format string, args ...interface{}) {
+	err := &CheckError{f.file, line, pos, fmt.Sprintf(format, args...)}
+	if f.dups == nil {
+		f.dups = map[string]bool{}
+	}
+	if key := err.Error(); !f.dups[key] {
+		f.dups[key] = true
+		f.errs = append(f.errs, err)
+	}
+}
+
+//sorted returns the errors in order, or nil if there are none.
+func (e CheckErrors) sorted() error {
+	if len(e) == 0 {
+		return nil
+	}
+	sort.SliceStable(e, func(i, j int) bool {
+		a, b := e[i], e[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Pos < b.Pos
+	})
+	return e
+}
+
+// ************
+// * Checking *
+// ************
+
+//Check reports problems in the template that would otherwise only show up
+//when the statements with them run:
+//
+//	evokes of blocks that aren't defined, unless the evoke is optional
+//	arguments passed to a block that doesn't declare them
+//	calls of functions that aren't attached or built in
+//	calls with the wrong number of arguments for the function or macro
+//	blocks that are defined by the template or the files it extends or
+//	includes, but never evoked
+//	blocks that evoke themselves, directly or through other blocks, without
+//	changing the context or passing arguments, which never stops
+//
+//Everything the template defines is checked, not just what the base template
+//renders. Blocks attached from files aren't reported as unused, and no block
+//is if the template evokes one by a name picked at runtime.
+func (t *Template) Check() error {
+	if err := t.compiled(); err != nil {
+		return err
+	}
+	t.compileLk.RLock()
+	defer t.compileLk.RUnlock()
+
+	ch := &checker{
+		ctx:  t.tree.context,
+		used: map[string]bool{},
+		seen: map[interface{}]bool{},
+	}
+
+	//find everything the base template reaches, then check the rest without
+	//counting what it evokes as used
+	ch.file = t.tree.file
+	ch.walk(t.tree.base)
+	ch.reached = true
+
+	names := sortedBlocks(ch.ctx.blocks)
+	for _, name := range names {
+		ch.walkBlock(ch.ctx.blocks[name])
+	}
+	for _, m := range ch.ctx.macros {
+		ch.walkMacro(m)
+	}
+	for _, tree := range ch.ctx.includes {
+		ch.walkInclude(tree)
+	}
+
+	if !ch.dynamic {
+		for _, name := range names {
+			if bl := ch.ctx.blocks[name]; !ch.used[name] && !bl.attached {
+				ch.file = bl.file
+				ch.errorf(bl.line, bl.pos, "block %s is never evoked", name)
+			}
+		}
+	}
+	ch.cycles(names)
+	return ch.errs.sorted()
+}
+
+//sortedBlocks returns the names of the blocks in order.
+func sortedBlocks(blocks map[string]*executeBlockValue) []string {
+	names := make([]string, 0, len(blocks))
+	for name := range blocks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//checker walks a parse tree looking for problems that don't depend on the
+//data it is executed with.
+type checker struct {
+	findings
+	ctx     *context
+	used    map[string]bool      //blocks evoked by what the base reaches
+	seen    map[interface{}]bool //blocks, macros and files already walked
+	reached bool                 //everything the base reaches has been walked
+	dynamic bool                 //a block is evoked by a name picked at runtime
+}
+
+func (ch *checker) walk(ex executer) {
+	switch e := ex.(type) {
+	case executeList:
+		for _, item := range e {
+			ch.walk(item)
+		}
+
+	case callValue:
+		ch.walkCall(e)
+	case *binaryValue:
+		ch.walk(e.left)
+		ch.walk(e.right)
+	case *negValue:
+		ch.walk(e.val)
+
+	case *executeIf:
+		ch.walk(e.cond)
+		ch.walk(e.succ)
+		ch.walk(e.fail)
+	case *executeSwitch:
+		ch.walk(e.val)
+		for _, cs := range e.cases {
+			for _, v := range cs.vals {
+				ch.walk(v)
+			}
+			ch.walk(cs.ex)
+		}
+		ch.walk(e.def)
+	case *executeWith:
+		ch.walk(e.ex)
+	case *executeRange:
+		ch.walk(e.iter)
+		ch.walk(e.ex)
+	case *executePush:
+		ch.walk(e.ex)
+	case *executeCapture:
+		ch.walk(e.ex)
+	case *executeEvoke:
+		ch.walkEvoke(e)
+	case *executeInclude:
+		if tree := ch.ctx.includes[e.abs]; tree != nil {
+			ch.walkInclude(tree)
+		}
+	}
+}
+
+//walkCall checks that the function or macro called exists and takes the
+//arguments given.
+func (ch *checker) walkCall(e callValue) {
+	for _, arg := range e.args {
+		ch.walk(arg)
+	}
+	name := string(e.name)
+	if m := ch.ctx.getMacro(name); m != nil {
+		if len(e.args) != len(m.params) {
+			ch.errorf(e.line, e.pos, "call %s: expected %d arguments got %d", name, len(m.params), len(e.args))
+		}
+		ch.walkMacro(m)
+		return
+	}
+	fnc := ch.ctx.getCall(name)
+	if !fnc.IsValid() {
+		ch.errorf(e.line, e.pos, "call %s: function not defined", name)
+		return
+	}
+	if err := checkArity(fnc.Type(), len(e.args)); err != nil {
+		ch.errorf(e.line, e.pos, "call %s: %v", name, err)
+	}
+}
+
+//walkEvoke checks that the evoked block exists and takes the arguments given.
+func (ch *checker) walkEvoke(e *executeEvoke) {
+	for _, arg := range e.args {
+		ch.walk(arg.val)
+	}
+	ch.walk(e.body)
+	for _, fill := range e.fills {
+		ch.walk(fill)
+	}
+	if e.name != nil {
+		ch.walk(e.name)
+		if !ch.reached {
+			ch.dynamic = true
+		}
+		return
+	}
+
+	bl := ch.ctx.getBlock(e.ident)
+	if bl == nil {
+		if !e.optional {
+			ch.errorf(e.line, e.pos, "no block by the name %s", e.ident)
+		}
+		return
+	}
+	if err := bl.checkArgs(e.args); err != nil {
+		ch.errorf(e.line, e.pos, "%v", err)
+	}
+	if !ch.reached {
+		ch.used[e.ident] = true
+	}
+	ch.walkBlock(bl)
+}
+
+//walkBlock checks a block definition and the definitions it overrides.
+func (ch *checker) walkBlock(bl *executeBlockValue) {
+	for ; bl != nil && !ch.seen[bl]; bl = bl.parent {
+		ch.seen[bl] = true
+		defer func(file string) { ch.file = file }(ch.file)
+		ch.file = bl.file
+		ch.walk(bl.ex)
+	}
+}
+
+//walkMacro checks the body of a macro.
+func (ch *checker) walkMacro(m *executeMacro) {
+	if ch.seen[m] {
+		return
+	}
+	ch.seen[m] = true
+	defer func(file string) { ch.file = file }(ch.file)
+	ch.file = m.file
+	ch.walk(m.ex)
+}
+
+//walkInclude checks an included file.
+func (ch *checker) walkInclude(tree *parseTree) {
+	if ch.seen[tree] {
+		return
+	}
+	ch.seen[tree] = true
+	defer func(file string) { ch.file = file }(ch.file)
+	ch.file = tree.file
+	ch.walk(tree.base)
+}
+
+// **********
+// * Cycles *
+// **********
+
+//edge is an evoke from one block of another in the same context.
+type edge struct {
+	to    string
+	file  string
+	evoke *executeEvoke
+}
+
+//cycles reports the blocks that evoke themselves without anything changing,
+//so that the execution would never end.
+func (ch *checker) cycles(names []string) {
+	graph := map[string][]edge{}
+	for _, name := range names {
+		bl := ch.ctx.blocks[name]
+		graph[name] = sameContextEvokes(bl, nil)
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := map[string]int{}
+	var stack []string
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, e := range graph[name] {
+			switch state[e.to] {
+			case unvisited:
+				visit(e.to)
+			case visiting:
+				var path []string
+				for i := len(stack) - 1; i >= 0; i-- {
+					if stack[i] == e.to {
+						path = append(append(path, stack[i:]...), e.to)
+						break
+					}
+				}
+				ch.file = e.file
+				ch.errorf(e.evoke.line, e.evoke.pos, "evoke cycle: %s", strings.Join(path, " -> "))
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = done
+	}
+	for _, name := range names {
+		if state[name] == unvisited {
+			visit(name)
+		}
+	}
+}
+
+//sameContextEvokes returns the evokes in the definition bl that evoke a block
+//without changing the context or passing arguments. A super, or a definition
+//that appends or prepends, brings in the evokes of the definition it
+//overrides. Evokes in a range, or behind an if or switch that depends on the
+//context, may never run, like the recursion over a tree, so they don't count.
+func sameContextEvokes(bl *executeBlockValue, edges []edge) []edge {
+	if bl.mode != blockReplace && bl.parent != nil {
+		edges = sameContextEvokes(bl.parent, edges)
+	}
+	return bodyEvokes(bl, bl.ex, edges)
+}
+
+//bodyEvokes adds the evokes in ex, part of the definition bl, to edges.
+func bodyEvokes(bl *executeBlockValue, ex executer, edges []edge) []edge {
+	switch e := ex.(type) {
+	case executeList:
+		for _, item := range e {
+			edges = bodyEvokes(bl, item, edges)
+		}
+	case *executeIf:
+		if branch, ok := e.constValue(); ok {
+			edges = bodyEvokes(bl, branch, edges)
+		}
+	case *executeSwitch:
+		if branch, ok := e.constValue(); ok {
+			edges = bodyEvokes(bl, branch, edges)
+		}
+	case *executePush:
+		edges = bodyEvokes(bl, e.ex, edges)
+	case *executeCapture:
+		edges = bodyEvokes(bl, e.ex, edges)
+	case *executeSuper:
+		if bl.mode == blockReplace && bl.parent != nil {
+			edges = sameContextEvokes(bl.parent, edges)
+		}
+	case *executeEvoke:
+		if e.name == nil && e.ctx == nil && len(e.args) == 0 {
+			edges = append(edges, edge{e.ident, bl.file, e})
+		}
+	}
+	return edges
+}
@@ -0,0 +1,96 @@
+package tmpl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	dir := createTestDir(t, []templateFile{
+		{"page.tmpl", `{% evoke header %}{% evoke nope %}{% evoke? maybe %}
+{% call upper .a %}{% call lower .a %}{% call upper .a .b %}{% call m .a %}
+{% evoke row item=.a extra=.b %}{% evoke ping %}{% evoke loop .a %}`},
+		{"parts.block", `{% block header %}{% call upper "x" %}{% end block %}
+{% block row as item %}{% .item %}{% end block %}
+{% block unused %}{% call missing %}{% end block %}
+{% block ping %}{% if 1 %}{% evoke pong %}{% end if %}{% end block %}
+{% block pong %}{% evoke ping %}{% end block %}
+{% block loop %}{% evoke loop .next %}{% end block %}
+{% macro m x y %}{% .x %}{% end macro %}`},
+	})
+	defer os.RemoveAll(dir)
+
+	page, parts := filepath.Join(dir, "page.tmpl"), filepath.Join(dir, "parts.block")
+	err := Parse(page).Blocks(parts).Call("upper", strings.ToUpper).Check()
+	errs, ok := err.(CheckErrors)
+	if !ok {
+		t.Fatalf("Expected CheckErrors got %v", err)
+	}
+
+	expect := []CheckError{
+		{page, 0, 27, "no block by the name nope"},
+		{page, 1, 46, "call upper: expected 1 arguments got 2"},
+		{page, 1, 68, "call m: expected 2 arguments got 1"},
+		{page, 2, 9, "block row has no parameter extra"},
+		{parts, 2, 26, "call missing: function not defined"},
+		{parts, 4, 25, "evoke cycle: ping -> pong -> ping"},
+	}
+	if len(errs) != len(expect) {
+		t.Fatalf("Expected %d errors got %d:\n%v", len(expect), len(errs), errs)
+	}
+	for i, ex := range expect {
+		if *errs[i] != ex {
+			t.Errorf("%d: Expected %v got %v", i, ex, errs[i])
+		}
+	}
+}
+
+func TestCheckUnusedBlocks(t *testing.T) {
+	dir := createTestDir(t, []templateFile{
+		{"layout.tmpl", `<{% block content %}{% end block %}>`},
+		{"page.tmpl", `{% extends "layout.tmpl" %}{% block content %}{% end block %}
+{% block sidebar %}{% end block %}`},
+		{"shared.block", `{% block content %}{% super %}{% end block %}{% block footer %}{% end block %}`},
+	})
+	defer os.RemoveAll(dir)
+
+	//only the blocks of the template itself are reported, not the shared ones
+	page := filepath.Join(dir, "page.tmpl")
+	err := Parse(page).Blocks(filepath.Join(dir, "shared.block")).Check()
+	errs, ok := err.(CheckErrors)
+	if !ok {
+		t.Fatalf("Expected CheckErrors got %v", err)
+	}
+	exp := CheckError{page, 1, 9, "block sidebar is never evoked"}
+	if len(errs) != 1 || *errs[0] != exp {
+		t.Errorf("Expected %v got %v", exp, errs)
+	}
+}
+
+func TestCheckClean(t *testing.T) {
+	dir := createTestDir(t, []templateFile{
+		{"base.tmpl", `{% evoke title %}{% evoke (.name) %}{% evoke? gone %}`},
+		{"page.tmpl", `{% extends "base.tmpl" %}{% block title %}{% super %}!{% end block %}`},
+		{"parts.block", `{% block title %}{% call upper .a %}{% end block %}
+{% block other %}{% evoke title %}{% end block %}
+{% block tree %}{% range .Children %}{% evoke tree %}{% end range %}{% end block %}
+{% block more %}{% if .More %}{% evoke more %}{% end if %}{% switch .Kind %}{% case 1 %}{% evoke more %}{% end switch %}{% end block %}`},
+	})
+	defer os.RemoveAll(dir)
+
+	j := func(path string) string {
+		return filepath.Join(dir, path)
+	}
+	tmpl := Parse(j("page.tmpl")).Blocks(j("parts.block")).Call("upper", strings.ToUpper)
+	if err := tmpl.Check(); err != nil {
+		t.Errorf("Expected no problems got %v", err)
+	}
+
+	if err := Parse(j("missing.tmpl")).Check(); err == nil {
+		t.Error("Expected an error for a missing file")
+	} else if _, ok := err.(CheckErrors); ok {
+		t.Errorf("Expected a compile error got %v", err)
+	}
+}
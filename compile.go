@@ -2,8 +2,10 @@ package tmpl
 
 import (
 	"bytes"
+	gocontext "context"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 )
 
@@ -12,15 +14,41 @@ import (
 type parseTree struct {
 	base    executer
 	context *context
+
+	//the template this one extends, relative to its file
+	extends string
+
+	//the file the tree was parsed from, and the includes in it
+	file     string
+	includes []*executeInclude
+
+	//the values in the front matter of the file
+	meta map[string]interface{}
 }
 
 //Execute runs the parsed template with the context value as the root.
 func (p *parseTree) Execute(w io.Writer, ctx interface{}) error {
+	return p.ExecuteContext(gocontext.Background(), w, ctx)
+}
+
+//ExecuteContext runs the parsed template with the data value as the root,
+//stopping as soon as ctx is cancelled or its deadline passes.
+func (p *parseTree) ExecuteContext(ctx gocontext.Context, w io.Writer, data interface{}) error {
 	if p.base == nil {
 		return nil
 	}
-	p.context.stack = pathRootedAt(ctx)
-	return p.base.Execute(w, p.context)
+	c := p.context.execution(ctx, data)
+	if err := c.done(); err != nil {
+		return err
+	}
+
+	//write through a stackWriter so stacks can be filled in at the end
+	sw := &stackWriter{w: w}
+	c.out = sw
+	if err := p.base.Execute(sw, c); err != nil {
+		return err
+	}
+	return sw.flush(c.stacks)
 }
 
 //String returns a nice printable representation of the parse tree and context.
@@ -42,6 +70,16 @@ type parser struct {
 	//block channel
 	blocks  chan *executeBlockValue
 	inBlock bool
+	inMacro bool
+	supers  *bool //set when the block being parsed uses super
+
+	//if the end evoke may never come, the state the parent picks up in when
+	//something else ends the statements first
+	optional bool
+	handback parseState
+
+	//the tree being parsed, for statements that describe the whole file
+	tree *parseTree
 
 	//token state types
 	curr   token //currently read token
@@ -65,6 +103,7 @@ func parse(toks chan token) (t *parseTree, err error) {
 			in:     toks,
 			errd:   tokenNone,
 			blocks: blocks,
+			tree:   t,
 		}
 		//set the base of the parse tree
 		t.base, err = subParse(p, tokenNoneType)
@@ -181,26 +220,47 @@ func (p *parser) acceptUntil(tok tokenType) (t []token) {
 	panic("unreachable")
 }
 
-//subParse starts another parser that runs until an end clause is encountered
-//of the given tokenType.
-func subParse(parp *parser, end tokenType) (ex executer, err error) {
-	//create our sub-parser
-	p := &parser{
+//newSubParser creates a parser for the statements inside of a statement that
+//runs until an end clause is encountered of the given tokenType.
+func newSubParser(parp *parser, end tokenType) *parser {
+	return &parser{
 		in:      parp.in,                           //use the same in channel
 		out:     make(chan executer),               //make a new out channel
 		end:     end,                               //look for the given end token
 		errd:    tokenNone,                         //we haven't errored yet
 		inBlock: parp.inBlock || end == tokenBlock, //check if we're in a block
+		inMacro: parp.inMacro || end == tokenMacro, //or a macro
+		supers:  parp.supers,                       //and report supers to it
 		blocks:  parp.blocks,                       //use the same block channel
+		tree:    parp.tree,                         //and the same tree
 	}
-	//run the parser
-	go p.run()
+}
 
-	//grab our executers
-	l := executeList{}
+//collect runs the sub parser p, returning everything it parsed, and sets the
+//token state on the parent to make backup/peek work.
+func (p *parser) collect(parp *parser) (l executeList) {
+	go p.run()
+	l = executeList{}
 	for e := range p.out {
 		l.Push(e)
 	}
+	parp.curr = p.curr
+	parp.backed = p.backed
+	parp.errd = p.errd
+	return
+}
+
+//subParse starts another parser that runs until an end clause is encountered
+//of the given tokenType.
+func subParse(parp *parser, end tokenType) (ex executer, err error) {
+	//create our sub-parser and grab our executers
+	p := newSubParser(parp, end)
+	l := p.collect(parp)
+
+	//fills only belong directly in the body of an evoke
+	if p.err == nil {
+		p.err = l.checkFills()
+	}
 	//compact the list for execute efficiency
 	l.compact()
 
@@ -215,13 +275,26 @@ func subParse(parp *parser, end tokenType) (ex executer, err error) {
 
 	//grab an error if it happened
 	err = p.err
+	return
+}
 
-	//set the token state on the parent to make backup/peek work
-	parp.curr = p.curr
-	parp.backed = p.backed
-	parp.errd = p.errd
+//evokeBody parses the statements after an evoke up to its end evoke. If
+//whatever ends the statements around the evoke comes first, the evoke has no
+//body, and the statements are returned with the state the parent picks up in
+//to handle what ended them.
+func evokeBody(parp *parser) (l executeList, next parseState, err error) {
+	p := newSubParser(parp, tokenEvoke)
+	p.optional = true
+	l = p.collect(parp)
+	return l, p.handback, p.err
+}
 
-	return
+//handBack stops an evoke body that turned out not to be one, leaving the
+//current token for the parent to handle in the state next.
+func (p *parser) handBack(next parseState) parseState {
+	p.backup()
+	p.handback = next
+	return nil
 }
 
 //parseText is the start state of the parser.
@@ -241,6 +314,9 @@ func parseText(p *parser) (s parseState) {
 		if p.end == tokenNoneType {
 			return nil
 		}
+		if p.optional {
+			return p.handBack(parseText)
+		}
 		return p.errorf("unexpected eof. in a %q context", p.end)
 	default:
 		return p.errorf("Unexpected token: %s", tok)
@@ -257,15 +333,62 @@ func parseOpen(p *parser) parseState {
 		if p.inBlock {
 			return p.errorf("%d:%d: nested blocks", tok.line, tok.pos)
 		}
+		if p.inMacro {
+			return p.errorf("%d:%d: block inside a macro", tok.line, tok.pos)
+		}
 		return parseBlock
+	case tok.typ == tokenMacro:
+		if p.inMacro {
+			return p.errorf("%d:%d: nested macros", tok.line, tok.pos)
+		}
+		if p.inBlock {
+			return p.errorf("%d:%d: macro inside a block", tok.line, tok.pos)
+		}
+		return parseMacro
 	case tok.typ == tokenWith:
 		return parseWith
 	case tok.typ == tokenRange:
 		return parseRange
 	case tok.typ == tokenIf:
 		return parseIf
-	case tok.typ == tokenEvoke:
+	case tok.typ == tokenSwitch:
+		return parseSwitch
+	case tok.typ == tokenEvoke, tok.typ == tokenEvokeOpt:
+		p.backup()
 		return parseEvoke
+	case tok.typ == tokenRaw:
+		return parseRaw
+	case tok.typ == tokenCapture:
+		return parseCapture
+	case tok.typ == tokenStackPush:
+		return parsePush
+	case tok.typ == tokenStack:
+		return parseStack
+	case tok.typ == tokenSlot:
+		return parseSlot
+	case tok.typ == tokenFill:
+		return parseFill
+	case tok.typ == tokenSuper:
+		if !p.inBlock || p.inMacro {
+			return p.errorf("%d:%d: super outside of a block", tok.line, tok.pos)
+		}
+		if tok := p.next(); tok.typ != tokenClose {
+			return p.errExpect(tokenClose, tok)
+		}
+		*p.supers = true
+		p.out <- &executeSuper{tok.line, tok.pos}
+		return parseText
+	case tok.typ == tokenInclude:
+		return parseInclude
+	case tok.typ == tokenExtends:
+		if p.inBlock || p.end != tokenNoneType {
+			return p.errorf("%d:%d: extends must be at the top level", tok.line, tok.pos)
+		}
+		return parseExtends
+
+	//the parts of an if or a switch end an evoke without a body
+	case p.optional && (tok.typ == tokenElse || tok.typ == tokenCase || tok.typ == tokenDefault):
+		return p.handBack(parseOpen)
 
 	//very special call to handle else
 	case tok.typ == tokenElse:
@@ -274,12 +397,19 @@ func parseOpen(p *parser) parseState {
 		}
 		return nil
 
+	//and the same for the parts of a switch
+	case tok.typ == tokenCase, tok.typ == tokenDefault:
+		if p.end != tokenSwitch {
+			return p.errorf("%d:%d: %s not inside a switch", tok.line, tok.pos, tok.dat)
+		}
+		return nil
+
 	//value calls
 	case isValueType(tok):
 		p.backup()
 		val, s := consumeValue(p)
 		if s != nil {
-			return p.errorf(s.Error())
+			return p.errorf("%v", s)
 		}
 
 		//grab the close
@@ -302,8 +432,18 @@ func parseOpen(p *parser) parseState {
 
 //parseEnd should signal the end of a sub parser
 func parseEnd(p *parser) parseState {
+	tok := p.next()
+
+	//anything but an end evoke ends an evoke without a body
+	if p.optional && tok.typ != tokenEvoke {
+		return p.handBack(parseEnd)
+	}
+
 	//didn't get the end we're looking for
-	if tok := p.next(); tok.typ != p.end {
+	if tok.typ == tokenEvoke && p.end != tokenEvoke {
+		return p.errorf("%d:%d: end evoke without an evoke body", tok.line, tok.pos)
+	}
+	if tok.typ != p.end {
 		return p.errExpect(p.end, tok)
 	}
 	if tok := p.next(); tok.typ != tokenClose {
@@ -312,11 +452,68 @@ func parseEnd(p *parser) parseState {
 	return nil
 }
 
+//parseSlot parses a slot action.
+func parseSlot(p *parser) parseState {
+	//grab the optional name
+	var name string
+	if tok := p.next(); tok.typ == tokenIdent {
+		name = string(tok.dat)
+	} else {
+		p.backup()
+	}
+
+	//consume the close
+	if tok := p.next(); tok.typ != tokenClose {
+		return p.errExpect(tokenClose, tok)
+	}
+
+	p.out <- &executeSlot{name}
+	return parseText
+}
+
+//parseFill parses a fill action.
+func parseFill(p *parser) parseState {
+	//grab the name of the slot
+	ident := p.next()
+	if ident.typ != tokenIdent {
+		return p.errExpect(tokenIdent, ident)
+	}
+
+	//consume the close
+	if tok := p.next(); tok.typ != tokenClose {
+		return p.errExpect(tokenClose, tok)
+	}
+
+	ex, err := subParse(p, tokenFill)
+	if err != nil {
+		return p.errorf("%v", err)
+	}
+
+	p.out <- &executeFill{string(ident.dat), ex, ident.line, ident.pos}
+	return parseText
+}
+
 //parseEvoke parses an evoke action.
 func parseEvoke(p *parser) parseState {
-	//grab the name
+	//an optional evoke renders nothing if the block is missing
+	optional := p.next().typ == tokenEvokeOpt
+
+	//grab the name, or the value that picks it
+	var name valueType
 	ident := p.next()
-	if ident.typ != tokenIdent {
+	switch ident.typ {
+	case tokenIdent:
+	case tokenLParen, tokenCall:
+		var err error
+		if ident.typ == tokenLParen {
+			name, err = consumeParenValue(p)
+		} else {
+			name, err = consumeCallValue(p)
+		}
+		if err != nil {
+			return p.errorf("%v", err)
+		}
+	default:
 		return p.errExpect(tokenIdent, ident)
 	}
 
@@ -326,8 +523,94 @@ func parseEvoke(p *parser) parseState {
 		var err error
 		ctx, err = consumeSelector(p)
 		if err != nil {
-			return p.errorf(err.Error())
+			return p.errorf("%v", err)
+		}
+	}
+
+	//grab any named arguments
+	var args []namedArg
+	for p.peek().typ == tokenIdent {
+		name := p.next()
+		if tok := p.next(); tok.typ != tokenAssign {
+			return p.errExpect(tokenAssign, tok)
 		}
+		for _, arg := range args {
+			if arg.name == string(name.dat) {
+				return p.errorf("%d:%d: argument %s given twice", name.line, name.pos, name.dat)
+			}
+		}
+		val, err := consumeValue(p)
+		if err != nil {
+			return p.errorf("%v", err)
+		}
+		args = append(args, namedArg{string(name.dat), val})
+	}
+
+	//grab the close
+	if tok := p.next(); tok.typ != tokenClose {
+		return p.errExpect(tokenClose, tok)
+	}
+
+	ev := &executeEvoke{
+		ident:    string(ident.dat),
+		name:     name,
+		optional: optional,
+		ctx:      ctx,
+		args:     args,
+		line:     ident.line,
+		pos:      ident.pos,
+	}
+
+	//an end evoke before whatever ends the statements around the evoke makes
+	//everything up to it the body
+	l, next, err := evokeBody(p)
+	if err != nil {
+		return p.errorf("%v", err)
+	}
+	if next == nil {
+		if err = ev.setBody(l); err != nil {
+			return p.errorf("%v", err)
+		}
+		p.out <- ev
+		return parseText
+	}
+
+	p.out <- ev
+	for _, ex := range l {
+		p.out <- ex
+	}
+	return next
+}
+
+//parseInclude parses an include action.
+func parseInclude(p *parser) parseState {
+	//grab the file name
+	name := p.next()
+	if name.typ != tokenValue {
+		return p.errExpect(tokenValue, name)
+	}
+	file, err := strconv.Unquote(string(name.dat))
+	if err != nil || file == "" {
+		return p.errorf("%d:%d: invalid file name %s", name.line, name.pos, name.dat)
+	}
+
+	//see if we have a context
+	var ctx *selectorValue
+	if p.peek().typ == tokenStartSel {
+		if ctx, err = consumeSelector(p); err != nil {
+			return p.errorf("%v", err)
+		}
+	}
+
+	//check for the ignore missing modifier
+	var ignore bool
+	if tok := p.next(); tok.typ == tokenIdent && string(tok.dat) == "ignore" {
+		if tok := p.next(); tok.typ != tokenIdent || string(tok.dat) != "missing" {
+			return p.errorf("%d:%d: expected ignore missing", tok.line, tok.pos)
+		}
+		ignore = true
+	} else {
+		p.backup()
 	}
 
 	//grab the close
@@ -335,7 +618,39 @@ func parseEvoke(p *parser) parseState {
 		return p.errExpect(tokenClose, tok)
 	}
 
-	p.out <- &executeEvoke{string(ident.dat), ctx}
+	inc := &executeInclude{
+		name:   file,
+		ctx:    ctx,
+		ignore: ignore,
+		line:   name.line,
+		pos:    name.pos,
+	}
+	p.tree.includes = append(p.tree.includes, inc)
+	p.out <- inc
+	return parseText
+}
+
+//parseExtends parses an extends action.
+func parseExtends(p *parser) parseState {
+	//grab the file name
+	name := p.next()
+	if name.typ != tokenValue {
+		return p.errExpect(tokenValue, name)
+	}
+	file, err := strconv.Unquote(string(name.dat))
+	if err != nil || file == "" {
+		return p.errorf("%d:%d: invalid file name %s", name.line, name.pos, name.dat)
+	}
+
+	//grab the close
+	if tok := p.next(); tok.typ != tokenClose {
+		return p.errExpect(tokenClose, tok)
+	}
+
+	if p.tree.extends != "" {
+		return p.errorf("%d:%d: already extends %q", name.line, name.pos, p.tree.extends)
+	}
+	p.tree.extends = file
 	return parseText
 }
 
@@ -347,19 +662,177 @@ func parseBlock(p *parser) parseState {
 		return p.errExpect(tokenIdent, ident)
 	}
 
+	//check for a modifier saying how to combine with the definition this
+	//one overrides
+	mode := blockReplace
+	if tok := p.next(); tok.typ == tokenIdent {
+		switch string(tok.dat) {
+		case "append":
+			mode = blockAppend
+		case "prepend":
+			mode = blockPrepend
+		default:
+			return p.errorf("%d:%d: unknown block modifier %q", tok.line, tok.pos, tok.dat)
+		}
+	} else {
+		p.backup()
+	}
+
+	//grab the parameters the block declares
+	var params []string
+	if p.accept(tokenAs) {
+		params = []string{}
+		for p.peek().typ == tokenIdent {
+			params = append(params, string(p.next().dat))
+		}
+		if len(params) == 0 {
+			return p.errExpect(tokenIdent, p.next())
+		}
+	}
+
 	//consume the close
 	if tok := p.next(); tok.typ != tokenClose {
 		return p.errExpect(tokenClose, tok)
 	}
 
 	//start a sub parser looking for an end block
+	outer, super := p.supers, new(bool)
+	p.supers = super
 	ex, err := subParse(p, tokenBlock)
+	p.supers = outer
 	if err != nil {
-		return p.errorf(err.Error())
+		return p.errorf("%v", err)
 	}
 
-	//send it to blocks instead of out
-	p.blocks <- &executeBlockValue{string(ident.dat), "", ex}
+	//send it to blocks and evoke it in place so the definition acts as the
+	//default content for that spot
+	p.blocks <- &executeBlockValue{ident: string(ident.dat), ex: ex, mode: mode, params: params, super: *super, line: ident.line, pos: ident.pos}
+	p.out <- &executeEvoke{ident: string(ident.dat), def: true, line: ident.line, pos: ident.pos}
+	return parseText
+}
+
+//parseMacro parses a macro definition.
+func parseMacro(p *parser) parseState {
+	//grab the name
+	ident := p.next()
+	if ident.typ != tokenIdent {
+		return p.errExpect(tokenIdent, ident)
+	}
+
+	//grab the parameters
+	var params []string
+	for p.peek().typ == tokenIdent {
+		params = append(params, string(p.next().dat))
+	}
+
+	//consume the close
+	if tok := p.next(); tok.typ != tokenClose {
+		return p.errExpect(tokenClose, tok)
+	}
+
+	ex, err := subParse(p, tokenMacro)
+	if err != nil {
+		return p.errorf("%v", err)
+	}
+
+	//macros don't render where they're defined, so they go straight to the
+	//tree
+	name := string(ident.dat)
+	if _, ex := p.tree.context.macros[name]; ex {
+		return p.errorf("%d:%d: Redefined macro %s", ident.line, ident.pos, name)
+	}
+	p.tree.context.macros[name] = &executeMacro{ident: name, params: params, ex: ex}
+	return parseText
+}
+
+//parseRaw parses a raw section, which the lexer sends as a single literal.
+func parseRaw(p *parser) parseState {
+	//consume the close
+	if tok := p.next(); tok.typ != tokenClose {
+		return p.errExpect(tokenClose, tok)
+	}
+
+	if tok := p.next(); tok.typ == tokenLiteral {
+		p.out <- constantValue(tok.dat)
+	} else {
+		p.backup()
+	}
+
+	//the lexer only stops at the end of the raw section
+	for _, typ := range []tokenType{tokenOpen, tokenEnd, tokenRaw, tokenClose} {
+		if tok := p.next(); tok.typ != typ {
+			return p.errExpect(typ, tok)
+		}
+	}
+	return parseText
+}
+
+//parseCapture parses a capture action.
+func parseCapture(p *parser) parseState {
+	//grab the name of the variable
+	ident := p.next()
+	if ident.typ != tokenIdent {
+		return p.errExpect(tokenIdent, ident)
+	}
+
+	//consume the close
+	if tok := p.next(); tok.typ != tokenClose {
+		return p.errExpect(tokenClose, tok)
+	}
+
+	ex, err := subParse(p, tokenCapture)
+	if err != nil {
+		return p.errorf("%v", err)
+	}
+
+	p.out <- &executeCapture{string(ident.dat), ex}
+	return parseText
+}
+
+//parsePush parses a push action.
+func parsePush(p *parser) parseState {
+	//grab the name of the stack
+	ident := p.next()
+	if ident.typ != tokenIdent {
+		return p.errExpect(tokenIdent, ident)
+	}
+
+	//check if we only push unique content
+	var once bool
+	if tok := p.next(); tok.typ == tokenIdent && string(tok.dat) == "once" {
+		once = true
+	} else {
+		p.backup()
+	}
+
+	//consume the close
+	if tok := p.next(); tok.typ != tokenClose {
+		return p.errExpect(tokenClose, tok)
+	}
+
+	ex, err := subParse(p, tokenStackPush)
+	if err != nil {
+		return p.errorf("%v", err)
+	}
+
+	p.out <- &executePush{string(ident.dat), once, ex}
+	return parseText
+}
+
+//parseStack parses a stack action.
+func parseStack(p *parser) parseState {
+	//grab the name of the stack
+	ident := p.next()
+	if ident.typ != tokenIdent {
+		return p.errExpect(tokenIdent, ident)
+	}
+
+	//consume the close
+	if tok := p.next(); tok.typ != tokenClose {
+		return p.errExpect(tokenClose, tok)
+	}
+
+	p.out <- &executeStack{string(ident.dat)}
 	return parseText
 }
 
@@ -368,7 +841,7 @@ func parseWith(p *parser) parseState {
 	//grab the value type
 	ctx, st := consumeSelector(p)
 	if st != nil {
-		return p.errorf(st.Error())
+		return p.errorf("%v", st)
 	}
 
 	//grab the close
@@ -378,7 +851,7 @@ func parseWith(p *parser) parseState {
 
 	ex, err := subParse(p, tokenWith)
 	if err != nil {
-		return p.errorf(err.Error())
+		return p.errorf("%v", err)
 	}
 
 	p.out <- &executeWith{ctx, ex}
@@ -390,7 +863,7 @@ func parseRange(p *parser) parseState {
 	//grab the value type
 	ctx, st := consumeValue(p)
 	if st != nil {
-		return p.errorf(st.Error())
+		return p.errorf("%v", st)
 	}
 
 	//default to none
@@ -417,7 +890,7 @@ func parseRange(p *parser) parseState {
 
 	ex, err := subParse(p, tokenRange)
 	if err != nil {
-		return p.errorf(err.Error())
+		return p.errorf("%v", err)
 	}
 
 	p.out <- &executeRange{ctx, ex, key, val}
@@ -429,7 +902,7 @@ func parseIf(p *parser) parseState {
 	//grab the value
 	cond, st := consumeValue(p)
 	if st != nil {
-		return p.errorf(st.Error())
+		return p.errorf("%v", st)
 	}
 
 	//grab the close
@@ -440,7 +913,7 @@ func parseIf(p *parser) parseState {
 	//start a sub parser for succ
 	succ, err := subParse(p, tokenIf)
 	if err != nil {
-		return p.errorf(err.Error())
+		return p.errorf("%v", err)
 	}
 
 	//backup to check how we exited
@@ -457,7 +930,7 @@ func parseIf(p *parser) parseState {
 		var err error
 		fail, err = subParse(p, tokenIf)
 		if err != nil {
-			return p.errorf(err.Error())
+			return p.errorf("%v", err)
 		}
 	case tokenClose:
 	default:
@@ -467,3 +940,75 @@ func parseIf(p *parser) parseState {
 	p.out <- &executeIf{cond, succ, fail}
 	return parseText
 }
+
+//parseSwitch parses a switch action.
+func parseSwitch(p *parser) parseState {
+	//grab the value
+	val, err := consumeValue(p)
+	if err != nil {
+		return p.errorf("%v", err)
+	}
+
+	//grab the close
+	if tok := p.next(); tok.typ != tokenClose {
+		return p.errExpect(tokenClose, tok)
+	}
+
+	//there can only be whitespace before the first case
+	pre, err := subParse(p, tokenSwitch)
+	if err != nil {
+		return p.errorf("%v", err)
+	}
+	if pre != nil {
+		return p.errorf("%d:%d: content before the first case of a switch", p.curr.line, p.curr.pos)
+	}
+
+	sw := &executeSwitch{val: val}
+	var hasDefault bool
+	for {
+		//backup to check how the last part ended
+		p.backup()
+		tok := p.next()
+		switch tok.typ {
+		case tokenCase:
+			if hasDefault {
+				return p.errorf("%d:%d: case after default", tok.line, tok.pos)
+			}
+			var vals []valueType
+			for isValueType(p.peek()) {
+				v, err := consumeValue(p)
+				if err != nil {
+					return p.errorf("%v", err)
+				}
+				vals = append(vals, v)
+			}
+			if len(vals) == 0 {
+				return p.errorf("%d:%d: case without a value", tok.line, tok.pos)
+			}
+			if tok := p.next(); tok.typ != tokenClose {
+				return p.errExpect(tokenClose, tok)
+			}
+			ex, err := subParse(p, tokenSwitch)
+			if err != nil {
+				return p.errorf("%v", err)
+			}
+			sw.cases = append(sw.cases, switchCase{vals, ex})
+		case tokenDefault:
+			if hasDefault {
+				return p.errorf("%d:%d: more than one default", tok.line, tok.pos)
+			}
+			hasDefault = true
+			if tok := p.next(); tok.typ != tokenClose {
+				return p.errExpect(tokenClose, tok)
+			}
+			if sw.def, err = subParse(p, tokenSwitch); err != nil {
+				return p.errorf("%v", err)
+			}
+		case tokenClose:
+			p.out <- sw
+			return parseText
+		default:
+			return p.unexpected(tok)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package tmpl
 
 import (
 	"bytes"
+	gocontext "context"
 	"fmt"
 	"reflect"
 	"strings"
@@ -65,8 +66,30 @@ type context struct {
 	stack  path
 	blocks map[string]*executeBlockValue
 	backup map[string]*executeBlockValue
+	macros map[string]*executeMacro
 	funcs  map[string]reflect.Value
 	set    map[string]reflect.Value
+	goctx  gocontext.Context
+	block  *executeBlockValue //the block being executed, for super
+	stacks map[string]*stack  //content pushed to stacks
+	out    *stackWriter       //the writer for the whole execution
+	slots  *slotFrame         //the body passed to the evoked block
+	depth  int                //how many macro calls deep the execution is
+
+	//includes are the trees of included files keyed by their absolute path
+	includes map[string]*parseTree
+
+	//the macros and includes to go back to after an execution, like backup
+	//for blocks
+	macroBackup   map[string]*executeMacro
+	includeBackup map[string]*parseTree
+
+	//builtins are the built in functions available, used when no function
+	//has been attached by the same name
+	builtins map[string]reflect.Value
+
+	//meta is the front matter of the template, reached through /.@meta
+	meta map[string]interface{}
 }
 
 //newContext creates a new empty context.
@@ -74,8 +97,47 @@ func newContext() *context {
 	return &context{
 		stack:  path{},
 		blocks: map[string]*executeBlockValue{},
+		macros: map[string]*executeMacro{},
 		funcs:  map[string]reflect.Value{},
 		set:    map[string]reflect.Value{},
+
+		builtins: builtins,
+		includes: map[string]*parseTree{},
+	}
+}
+
+//clone returns a copy of the context whose blocks and functions can be
+//changed without affecting c.
+func (c *context) clone() *context {
+	n := newContext()
+	for key := range c.blocks {
+		n.blocks[key] = c.blocks[key]
+	}
+	for key := range c.macros {
+		n.macros[key] = c.macros[key]
+	}
+	for key := range c.funcs {
+		n.funcs[key] = c.funcs[key]
+	}
+	n.builtins = c.builtins
+	return n
+}
+
+//execution returns a context for a single execution rooted at data. It shares
+//the blocks and functions of c but gets its own stack and path overrides so
+//that concurrent executions don't step on each other.
+func (c *context) execution(ctx gocontext.Context, data interface{}) *context {
+	return &context{
+		stack:  pathRootedAt(data),
+		blocks: c.blocks,
+		macros: c.macros,
+		funcs:  c.funcs,
+		set:    map[string]reflect.Value{},
+		goctx:  ctx,
+
+		builtins: c.builtins,
+		includes: c.includes,
+		meta:     c.meta,
 	}
 }
 
@@ -98,22 +160,41 @@ func (c *context) setFile(file string) {
 	for _, val := range c.blocks {
 		val.file = file
 	}
+	for _, val := range c.macros {
+		val.file = file
+	}
 }
 
-//dup duplicates all the blocks into the backup value
+//dup duplicates all the blocks, macros and includes into the backup values
 func (c *context) dup() {
 	c.backup = map[string]*executeBlockValue{}
 	for key := range c.blocks {
 		c.backup[key] = c.blocks[key]
 	}
+	c.macroBackup = map[string]*executeMacro{}
+	for key := range c.macros {
+		c.macroBackup[key] = c.macros[key]
+	}
+	c.includeBackup = map[string]*parseTree{}
+	for key := range c.includes {
+		c.includeBackup[key] = c.includes[key]
+	}
 }
 
-//restore copies all the blocks from the backup value into the blocks value
+//restore copies all the blocks, macros and includes from the backup values
 func (c *context) restore() {
 	c.blocks = map[string]*executeBlockValue{}
 	for key := range c.backup {
 		c.blocks[key] = c.backup[key]
 	}
+	c.macros = map[string]*executeMacro{}
+	for key := range c.macroBackup {
+		c.macros[key] = c.macroBackup[key]
+	}
+	c.includes = map[string]*parseTree{}
+	for key := range c.includeBackup {
+		c.includes[key] = c.includeBackup[key]
+	}
 }
 
 //valueFor grabs the value for specified selector
@@ -124,7 +205,10 @@ func (c *context) valueFor(s *selectorValue) (rv reflect.Value, err error) {
 		err = fmt.Errorf("%q: can't get the value for a nil selector", c.stack)
 		return
 	case s.abs: //absolute selector starts at top
-		pth = path(c.stack[:1])
+		var keys []string
+		pth, keys = c.rootPath(s.path)
+		rv, err = pth.valueAt(keys, c.set)
+		return
 	case s.pops < 0 || s.pops >= len(c.stack):
 		err = fmt.Errorf("%q: cant pop %d items", c.stack, s.pops)
 		return
@@ -138,6 +222,18 @@ func (c *context) valueFor(s *selectorValue) (rv reflect.Value, err error) {
 	return
 }
 
+//rootPath returns the path a rooted selector along keys starts at, and the
+//keys left to follow from there. Selectors starting with @meta start at the
+//front matter instead of the data.
+func (c *context) rootPath(keys []string) (path, []string) {
+	pth := path(c.stack[:1:1])
+	if len(keys) > 0 && keys[0] == metaKey {
+		pth = append(pth, pathItem{name: metaKey, val: reflect.ValueOf(c.meta)})
+		keys = keys[1:]
+	}
+	return pth, keys
+}
+
 //cd changes the path to the specified selector value
 func (c *context) cd(s *selectorValue) (err error) {
 	switch {
@@ -145,7 +241,10 @@ func (c *context) cd(s *selectorValue) (err error) {
 		err = fmt.Errorf("%q: can't get the value for a nil selector", c.stack)
 		return
 	case s.abs: //absolute selector means start back at the top
-		c.stack = c.stack[:1]
+		var keys []string
+		c.stack, keys = c.rootPath(s.path)
+		err = c.stack.cd(keys, c.set)
+		return
 	case s.pops < 0 || s.pops >= len(c.stack):
 		err = fmt.Errorf("%q: cant pop %d items", c.stack, s.pops)
 		return
@@ -161,14 +260,93 @@ func (c *context) setStack(p path) {
 	c.stack = p
 }
 
+//goContext returns the context.Context the execution is running under.
+func (c *context) goContext() gocontext.Context {
+	if c.goctx == nil {
+		return gocontext.Background()
+	}
+	return c.goctx
+}
+
+//done returns the reason the execution should stop early, if any.
+func (c *context) done() error {
+	if c.goctx == nil {
+		return nil
+	}
+	return c.goctx.Err()
+}
+
+//override makes a copy of the block from file the definition for its name,
+//keeping the definition it replaces as its parent, and returns the copy.
+func (c *context) override(bl *executeBlockValue, file string) *executeBlockValue {
+	cp := &executeBlockValue{
+		ident:  bl.ident,
+		file:   file,
+		ex:     bl.ex,
+		parent: c.blocks[bl.ident],
+		mode:   bl.mode,
+		params: bl.params,
+		super:  bl.super,
+		line:   bl.line,
+		pos:    bl.pos,
+	}
+	c.blocks[bl.ident] = cp
+	return cp
+}
+
+//fallback adds the block to the context only if there is no block by that
+//name already.
+func (c *context) fallback(bl *executeBlockValue, file string) {
+	if _, ex := c.blocks[bl.ident]; !ex {
+		c.override(bl, file)
+	}
+}
+
+//push adds content to the named stack. If once is set, the content is only
+//added if it hasn't been pushed to the stack before.
+func (c *context) push(ident string, content []byte, once bool) {
+	if c.stacks == nil {
+		c.stacks = map[string]*stack{}
+	}
+	st, ex := c.stacks[ident]
+	if !ex {
+		st = &stack{seen: map[string]bool{}}
+		c.stacks[ident] = st
+	}
+	if once && st.seen[string(content)] {
+		return
+	}
+	st.seen[string(content)] = true
+	st.items = append(st.items, content)
+}
+
 //getBlock returns the block with the given name
 func (c *context) getBlock(name string) *executeBlockValue {
 	return c.blocks[name]
 }
 
-//getCall returns the function value with the given name
+//getMacro returns the macro with the given name, unless a function attached
+//with Call or Funcs has the name, as those take precedence over macros.
+func (c *context) getMacro(name string) *executeMacro {
+	if _, ex := c.funcs[name]; ex {
+		return nil
+	}
+	if _, ex := globalFunc(name); ex {
+		return nil
+	}
+	return c.macros[name]
+}
+
+//getCall returns the function value with the given name, falling back to the
+//functions attached with Funcs and then the built in functions.
 func (c *context) getCall(name string) reflect.Value {
-	return c.funcs[name]
+	if fnc, ex := c.funcs[name]; ex {
+		return fnc
+	}
+	if fnc, ex := globalFunc(name); ex {
+		return fnc
+	}
+	return c.builtins[name]
 }
 
 //setAt sets a value for the given path, overriding whatever is there
@@ -178,6 +356,37 @@ func (c *context) setAt(path string, value interface{}) {
 	}
 }
 
+//bind sets variables relative to the current path, returning a function that
+//puts back whatever they hid.
+func (c *context) bind(vars map[string]interface{}) (restore func()) {
+	type saved struct {
+		val reflect.Value
+		ex  bool
+	}
+	prev := map[string]saved{}
+	for name, val := range vars {
+		pth := c.stack.StringWith([]string{name})
+		old, ex := c.set[pth]
+		prev[pth] = saved{old, ex}
+
+		//nil still needs to be a value so the variable hides the data
+		rv := reflect.ValueOf(&val).Elem()
+		if val != nil {
+			rv = reflect.ValueOf(val)
+		}
+		c.set[pth] = rv
+	}
+	return func() {
+		for pth, s := range prev {
+			if s.ex {
+				c.set[pth] = s.val
+			} else {
+				delete(c.set, pth)
+			}
+		}
+	}
+}
+
 //unsertAt deletes the value for the given path.
 func (c *context) unsetAt(path string) {
 	if path != "" {
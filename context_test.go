@@ -10,7 +10,7 @@ type d map[string]interface{}
 func TestContextSetPath(t *testing.T) {
 	c := newContext()
 	c.stack = pathRootedAt(nil)
-	sel := &selectorValue{0, false, []string{"foo"}}
+	sel := &selectorValue{0, false, []string{"foo"}, 0, 0}
 	c.set["/.foo"] = reflect.ValueOf("baz")
 	val, err := c.valueFor(sel)
 	if err != nil {
@@ -25,7 +25,7 @@ func TestContextNestedMaps(t *testing.T) {
 	nested := d{"foo": d{"bar": d{"baz": "baz"}}}
 	c := newContext()
 	c.stack = pathRootedAt(nested)
-	sel := &selectorValue{0, false, []string{"foo", "bar", "baz"}}
+	sel := &selectorValue{0, false, []string{"foo", "bar", "baz"}, 0, 0}
 	val, err := c.valueFor(sel)
 	if err != nil {
 		t.Fatal(err)
@@ -44,7 +44,7 @@ func TestContextNestedStructs(t *testing.T) {
 	nested := Item{Foo{Bar{Baz("baz")}}}
 	c := newContext()
 	c.stack = pathRootedAt(nested)
-	sel := &selectorValue{0, false, []string{"Foo", "Bar", "Baz"}}
+	sel := &selectorValue{0, false, []string{"Foo", "Bar", "Baz"}, 0, 0}
 	val, err := c.valueFor(sel)
 	if err != nil {
 		t.Fatal(err)
@@ -63,7 +63,7 @@ func TestContextInvalidStructKey(t *testing.T) {
 	nested := Item{Foo{Bar{Baz("baz")}}}
 	c := newContext()
 	c.stack = pathRootedAt(nested)
-	sel := &selectorValue{0, false, []string{"Foo", "Bar", "az"}}
+	sel := &selectorValue{0, false, []string{"Foo", "Bar", "az"}, 0, 0}
 	_, err := c.valueFor(sel)
 	if err == nil {
 		t.Fatal("expected error")
@@ -74,7 +74,7 @@ func TestContextInvalidMapKey(t *testing.T) {
 	nested := d{"foo": d{"bar": d{"baz": "baz"}}}
 	c := newContext()
 	c.stack = pathRootedAt(nested)
-	sel := &selectorValue{0, false, []string{"foo", "bar", "az"}}
+	sel := &selectorValue{0, false, []string{"foo", "bar", "az"}, 0, 0}
 	_, err := c.valueFor(sel)
 	if err == nil {
 		t.Fatal("expected error")
@@ -0,0 +1,168 @@
+package tmpl
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+func isIntKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+func isUintKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+func isFloatKind(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+func isNumericKind(k reflect.Kind) bool {
+	return isIntKind(k) || isUintKind(k) || isFloatKind(k)
+}
+
+//convertArg converts a value from a template into a value that can be passed
+//as a parameter of the given type. A nil becomes the zero value of the type,
+//numbers are converted between numeric types as long as they fit, and values
+//are converted between named types that share a kind.
+func convertArg(val interface{}, typ reflect.Type) (rv reflect.Value, err error) {
+	if val == nil {
+		return reflect.Zero(typ), nil
+	}
+
+	rv = reflect.ValueOf(val)
+	switch vtyp := rv.Type(); {
+	case vtyp.AssignableTo(typ):
+		return
+	case isNumericKind(vtyp.Kind()) && isNumericKind(typ.Kind()):
+		return convertNumeric(rv, typ)
+	case vtyp.Kind() == typ.Kind() && vtyp.ConvertibleTo(typ):
+		return rv.Convert(typ), nil
+	}
+	return reflect.Value{}, fmt.Errorf("can't use %v as %v", rv.Type(), typ)
+}
+
+//convertNumeric converts the numeric value into the numeric type, returning
+//an error if the value overflows the type or a float would be truncated.
+func convertNumeric(rv reflect.Value, typ reflect.Type) (out reflect.Value, err error) {
+	out = reflect.New(typ).Elem()
+	overflow := fmt.Errorf("%v overflows %v", rv.Interface(), typ)
+
+	switch k := rv.Kind(); {
+	case isIntKind(k):
+		i := rv.Int()
+		switch tk := typ.Kind(); {
+		case isIntKind(tk):
+			if out.OverflowInt(i) {
+				return reflect.Value{}, overflow
+			}
+			out.SetInt(i)
+		case isUintKind(tk):
+			if i < 0 || out.OverflowUint(uint64(i)) {
+				return reflect.Value{}, overflow
+			}
+			out.SetUint(uint64(i))
+		default:
+			out.SetFloat(float64(i))
+		}
+
+	case isUintKind(k):
+		u := rv.Uint()
+		switch tk := typ.Kind(); {
+		case isIntKind(tk):
+			if u > math.MaxInt64 || out.OverflowInt(int64(u)) {
+				return reflect.Value{}, overflow
+			}
+			out.SetInt(int64(u))
+		case isUintKind(tk):
+			if out.OverflowUint(u) {
+				return reflect.Value{}, overflow
+			}
+			out.SetUint(u)
+		default:
+			out.SetFloat(float64(u))
+		}
+
+	default:
+		f := rv.Float()
+		tk := typ.Kind()
+		if !isFloatKind(tk) && f != math.Trunc(f) {
+			return reflect.Value{}, fmt.Errorf("%v would be truncated to an %v", f, typ)
+		}
+		switch {
+		case isIntKind(tk):
+			if f < math.MinInt64 || f >= math.MaxInt64 || out.OverflowInt(int64(f)) {
+				return reflect.Value{}, overflow
+			}
+			out.SetInt(int64(f))
+		case isUintKind(tk):
+			if f < 0 || f >= math.MaxUint64 || out.OverflowUint(uint64(f)) {
+				return reflect.Value{}, overflow
+			}
+			out.SetUint(uint64(f))
+		default:
+			if out.OverflowFloat(f) {
+				return reflect.Value{}, overflow
+			}
+			out.SetFloat(f)
+		}
+	}
+	return
+}
+
+//numericEqual reports whether two numeric values are equal, comparing across
+//signed, unsigned and floating point types by value.
+func numericEqual(a, b reflect.Value) bool {
+	ak, bk := a.Kind(), b.Kind()
+	switch {
+	case isFloatKind(ak) || isFloatKind(bk):
+		return toFloat(a) == toFloat(b)
+	case isIntKind(ak) && isIntKind(bk):
+		return a.Int() == b.Int()
+	case isUintKind(ak) && isUintKind(bk):
+		return a.Uint() == b.Uint()
+	case isIntKind(ak):
+		return a.Int() >= 0 && uint64(a.Int()) == b.Uint()
+	default:
+		return b.Int() >= 0 && uint64(b.Int()) == a.Uint()
+	}
+}
+
+//toFloat returns the value of a numeric reflect value as a float64.
+func toFloat(v reflect.Value) float64 {
+	switch k := v.Kind(); {
+	case isIntKind(k):
+		return float64(v.Int())
+	case isUintKind(k):
+		return float64(v.Uint())
+	}
+	return v.Float()
+}
+
+//equal reports whether two values from a template are equal. Numbers compare
+//by value regardless of their types, strings and bools compare by value
+//regardless of their named types, and anything else must be deeply equal.
+func equal(a, b interface{}) bool {
+	av, bv := indirect(reflect.ValueOf(a)), indirect(reflect.ValueOf(b))
+	if !av.IsValid() || !bv.IsValid() {
+		return !av.IsValid() && !bv.IsValid()
+	}
+	switch ak, bk := av.Kind(), bv.Kind(); {
+	case isNumericKind(ak) && isNumericKind(bk):
+		return numericEqual(av, bv)
+	case ak == reflect.String && bk == reflect.String:
+		return av.String() == bv.String()
+	case ak == reflect.Bool && bk == reflect.Bool:
+		return av.Bool() == bv.Bool()
+	}
+	return reflect.DeepEqual(av.Interface(), bv.Interface())
+}
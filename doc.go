@@ -13,6 +13,24 @@ to specify the action the template system should take. All actions begin with
 a keyword like "block" or "evoke", except in the case of printing a value from
 a context, where just the selector is specified.
 
+Statements may be split across lines anywhere a space is allowed, which keeps
+long calls readable.
+
+	{% call printf "%s has %d items"
+		.Name
+		.Count %}
+
+To write a literal '{%' or '{#' in the output, put a backslash in front of it,
+and the backslash is dropped. Larger pieces of text, like templates meant for
+the browser that use the same syntax, can be wrapped in a raw section, which
+is written out untouched up to the {% end raw %}.
+
+	\{% this is not an action %}
+
+	{% raw %}
+		{% these %}{# are all #}{% just text %}
+	{% end raw %}
+
 Contexts
 
 Contexts are the origin for all of the values a template has access to. The main
@@ -63,7 +81,33 @@ corresponds to the function call
 	foo(.Bar, .Baz)
 
 with the selectors .Bar and .Baz evaluated. See Template.Call for details on
-how to attach a function.
+how to attach a function. Functions whose first parameter is a context.Context
+receive the context the template is being executed under ahead of the other
+arguments, which lets them reach request scoped values and deadlines.
+
+Arguments are converted to the types of the function's parameters before the
+call. Numbers convert between any numeric types as long as the value fits
+without overflowing or dropping a fraction, a nil argument becomes the zero
+value of its parameter, and values convert between named types of the same
+kind. Variadic functions accept any number of trailing arguments. A call with
+the wrong number of arguments, or with an argument that can't be converted,
+fails the execution with an error naming the argument.
+
+An argument may itself be a call, or any other value, grouped in parenthesis.
+
+	{% call upper (call join .Names ", ") %}
+
+Following the convention of text/template, a function may return an error as
+its last result. If the error is non nil the execution stops and returns an
+error naming the function and the position of the call; otherwise the error is
+dropped and the remaining result is used as the value of the call.
+
+Functions used by every template can be attached once with the Funcs function
+instead of attaching them to each Template. A function attached to a Template
+with Call takes precedence over one attached with Funcs.
+
+Arguments may be selectors, double quoted strings with the usual Go escapes,
+or numbers.
 
 	{% call name [args...] %}
 
@@ -71,27 +115,258 @@ how to attach a function.
 	{% call add .FirstNumber .SecondNumber %}
 	{% call not .Value %}
 	{% call equal .FirstName .OtherUser %}
+	{% call printf "%d items" .Count %}
+
+Built-in Functions
+
+Every template can call a set of built-in functions without attaching them.
+A function attached with Template.Call or Funcs takes precedence over a
+built-in of the same name, and Template.Builtins restricts which built-ins are
+available or turns them off entirely.
+
+	len x
+		The length of a string, slice, array, map or channel.
+	eq x y [z...]
+		Whether x is equal to any of the following arguments. Numbers are
+		equal if they have the same value, whatever their types.
+	not x
+		Whether x is not "truthy". See the If statement.
+	and x [y...]
+		The first argument that is not truthy, or the last argument.
+	or x [y...]
+		The first argument that is truthy, or the last argument.
+	join list sep
+		The items of a slice or array formatted and joined with sep.
+	upper x
+		x formatted and converted to upper case.
+	lower x
+		x formatted and converted to lower case.
+	printf format [args...]
+		The result of fmt.Sprintf.
+	urlquery [args...]
+		The arguments formatted and escaped to be placed in a URL query.
+	json x
+		x encoded as JSON.
+	slice x [i [j]]
+		x[i:j] for a string, slice or array.
+	first x
+		The first item of a slice or array, or the first character of a
+		string.
+	last x
+		The last item of a slice or array, or the last character of a
+		string.
+
+Expressions
+
+Anywhere a value is expected, values may be combined with the arithmetic
+operators + - * / and %, a leading minus, and the concatenation operator ~.
+Multiplication, division and remainder bind tighter than addition and
+subtraction, which bind tighter than concatenation, and parenthesis group.
+The arguments of a call are single values, so an operator after them applies
+to the result of the call, and an expression passed as an argument needs
+parenthesis.
+
+	{% .Price * .Quantity %}
+	{% if .Index % 2 %}odd{% end if %}
+	{% .Width + 10 ~ "px" %}
+	{% call price (.Amount * 2) %}
+
+Numbers follow the rules of Go. Values of the same type give a value of that
+type, while mixed types give a float64 if either is a float, a uint64 if both
+are unsigned, or an int64 otherwise. Remainder is only defined on integers,
+and + also joins two strings. Concatenation formats both sides like they
+would be printed, so it works on any values.
+
+A sign directly after a value, like .a-1, subtracts, while one following a
+space, like call f .a -1, starts a negative number. Put spaces around
+operators to avoid surprises.
+
+Dividing by zero, or applying an operator to values it isn't defined on,
+stops the execution with an error giving the position of the operator. An
+expression made only of constants is worked out when the template is
+compiled, so those errors are reported by Compile or Execute.
 
 Statement - Block
 
 Defines a block with the name, myName. Block definitions must end with an
-{% end block %} statement.
+{% end block %} statement. A block is rendered in place where it is defined,
+using its own contents as the default. A block of the same name in a file
+attached with Template.Blocks, or passed to Template.Execute, replaces the
+default contents, which lets a base template lay out a page and leave the
+pieces to be filled in.
 
 	{% block myName %}...{% end block %}
 
 	{% block greeting %}Hello!{% end block %}
 	{% block fullName %}{% .FirstName %} {% .LastName %}{% end block %}
 
+A block may declare named parameters after as. Inside the block they are
+variables relative to its context, and any that weren't passed are nil.
+
+	{% block button as label href %}
+		<a href="{% .href %}">{% .label %}</a>
+	{% end block %}
+
+Statement - Macro
+
+Defines a function in the template, named myName, that takes the listed
+parameters. Calling it with call renders its contents, and the output is the
+value of the call. Inside the macro the arguments are the context, keyed by
+the parameter names, and popping with $ reaches the context of the caller.
+Unlike blocks, macros render nothing where they are defined. They are attached
+from files with Template.Blocks and Template.Execute just like blocks. A
+macro hides a built in function of the same name, but a function attached
+with Template.Call or Funcs takes precedence over the macro. Macros can call
+themselves, but past 1000 calls deep the execution stops with an error.
+
+	{% macro myName [param ...] %}...{% end macro %}
+
+	{% macro price amount currency %}
+		<span class="{% .currency %}">{% .amount %}</span>
+	{% end macro %}
+	{% call price .Total "USD" %}
+
+Statement - Extends
+
+Declares that the template is laid out by another template. Executing the
+template renders the template it extends, with the blocks defined here
+replacing the blocks of the same name, and anything outside of a block
+ignored. The file name is relative to the file containing the statement, and
+templates may extend templates that extend others to any depth, with the most
+derived definition of a block winning. Extends may only appear at the top level
+of a template, and only once.
+
+	{% extends "file" %}
+
+	//file: layouts/site.tmpl
+	<title>{% block title %}My Site{% end block %}</title>
+	{% block content %}{% end block %}
+
+	//file: users/show.tmpl
+	{% extends "../layouts/site.tmpl" %}
+	{% block content %}Hello {% .Name %}!{% end block %}
+
+Statement - Super
+
+Inside of a block, renders the definition of the block it replaces. This lets
+a block add to a definition from a template it extends, or from a file that
+was attached before it, instead of replacing it outright. Definitions are
+layered from the least derived template to the most derived, then the files
+attached with Template.Blocks in the order given, then the files passed to
+Template.Execute, and super may be used at every layer. A file can replace a
+block from the templates outright, but a block attached from a file can only
+be built on by a later file, with super or by appending or prepending, and
+files matched by the same glob can't define the same block at all, as their
+order is arbitrary.
+
+	{% super %}
+
+	{% block head_meta %}
+		{% super %}
+		<script src="/js/page.js"></script>
+	{% end block %}
+
+For the common cases of adding to the start or end of a definition, a block
+can be marked append or prepend instead of calling super.
+
+	{% block scripts append %}
+		<script src="/js/page.js"></script>
+	{% end block %}
+
+Statement - Capture
+
+Renders its contents and binds the output to a variable, instead of writing
+it out. The variable is available to the rest of the statements around the
+capture, just like the variables of a range, so a piece of markup can be
+rendered once and used many times or handed to a function. The captured output
+is emitted again exactly as it was rendered, without any escaping, so it is
+treated as trusted markup wherever the variable is used.
+
+	{% capture name %}...{% end capture %}
+
+	{% capture title %}{% .Product.Name %} - {% .Site %}{% end capture %}
+	<title>{% .title %}</title>
+	<meta property="og:title" content="{% .title %}">
+
+Statement - Push and Stack
+
+Push renders its contents and adds them to a named stack, and stack emits
+everything pushed to that stack during the execution, no matter if the push
+happens before or after it. This lets a partial deep in the page ask for a
+script or stylesheet that the layout renders in the head. With once, content
+that is already on the stack is not pushed again.
+
+	{% push name %}contents{% end push %}
+	{% push name once %}contents{% end push %}
+	{% stack name %}
+
+	<head>{% stack scripts %}</head>
+	...
+	{% push scripts once %}<script src="/js/chart.js"></script>{% end push %}
+
+Output after the first stack is held until the execution finishes so the
+stacks can be filled in. Since a stack is only filled in then, it must be part
+of the output itself, and a stack inside a capture, macro or push is an error.
+
+Statement - Include
+
+Renders another file in place, with the optional context argument pushed as
+its context. The file is relative to the file including it, and is loaded and
+cached following the same rules as every other file (see Modes). Any blocks
+the included file defines can be evoked by the template, unless the template
+defines a block by the same name itself. Including a file that doesn't exist
+is an error, unless the include is marked ignore missing, and so is a file
+that includes itself, directly or through others.
+
+	{% include "file" [context] [ignore missing] %}
+
+	{% include "partials/nav.tmpl" .Menu %}
+	{% include "partials/banner.tmpl" ignore missing %}
+
 Statement - Evoke
 
 Substitutes this statement with the contents of the block, myBlock. The
 optional context argument pushes a sub-context into the block.
 
-	{% evoke myName [context] %}
+	{% evoke myName [context] [name=value ...] %}
 
 	{% evoke greeting %}
 	{% evoke fullName .LoggedInUser %}
 
+The name may instead be a value in parenthesis or a call that gives the name
+of the block at runtime. With evoke?, a block that doesn't exist renders
+nothing instead of failing the execution.
+
+	{% evoke (.Widget.Kind) .Widget %}
+	{% evoke call blockFor .Record %}
+	{% evoke? sidebar %}
+
+Named arguments are bound to the parameters the block declares. They are
+evaluated before the context argument is pushed, and passing a name the block
+doesn't declare is an error.
+
+	{% evoke button label=.Title href=.URL %}
+
+An evoke may also pass a body to the block, ended by {% end evoke %}. The
+block renders the body with {% slot %}, and content for named slots is given
+with fill. Slots are rendered in the context of the evoke, not the block. An
+end evoke closes the closest evoke before it that is inside the same
+statement, so an evoke without a body can't be directly inside the body of
+another, and an end evoke with no evoke to close is an error.
+
+	{% evoke myName [context] [name=value ...] %}...{% end evoke %}
+	{% slot [name] %}
+	{% fill name %}...{% end fill %}
+
+	{% block card %}
+		<div class="card">{% slot %}<footer>{% slot footer %}</footer></div>
+	{% end block %}
+
+	{% evoke card .Item %}
+		<p>{% .Description %}</p>
+		{% fill footer %}{% .Price %}{% end fill %}
+	{% end evoke %}
+
 Statement - Range
 
 Iterates over the given value. A value can be either the result of a .Selector
@@ -140,6 +415,23 @@ otherwise, it executes the negative template if given.
 		Negative: No one is logged in.
 	{% end if %}
 
+Statement - Switch
+
+Evaluates the value and executes the first case with a value equal to it, or
+the default if no case matches. Equality is the same as the eq function, so
+numbers compare by value whatever their types and named string types compare
+equal to plain strings. Only whitespace may come before the first case, and
+the default must come last. A switch on constants is decided when the
+template is compiled.
+
+	{% switch value %}{% case value [value ...] %}...[{% default %}...]{% end switch %}
+
+	{% switch .Order.Status %}
+		{% case "paid" "settled" %}Thank you!
+		{% case "refunded" %}Your money is on the way back.
+		{% default %}Awaiting payment.
+	{% end switch %}
+
 Statement - With
 
 With takes the specified selector and roots a sub-context at that position in
@@ -163,6 +455,85 @@ the context.
 		{% end with %}
 	{% end with %}
 
+Front Matter
+
+A template file may start with front matter holding values about the page,
+like its title or who may see it, once it is turned on with
+Template.FrontMatter or Set.FrontMatter. Without it, files are used as is. YAML
+is fenced by lines of ---, TOML by lines of +++, and JSON is an object that
+starts the file and ends its line. Only the common parts of YAML and TOML are
+understood: keys with strings, numbers, booleans, nulls and lists, nested
+mappings and lists of mappings in YAML, and tables in TOML. Anything else, like
+YAML block scalars or anchors, is an error.
+
+	---
+	title: Users
+	perms: [admin]
+	---
+	{% extends "../layouts/site.tmpl" %}
+
+The values are available to Go through Template.Meta, and to the template
+itself by starting a rooted selector with @meta. The front matter of a template
+is layered over the front matter of the templates it extends, so a layout can
+give defaults that pages replace. Front matter in block files and included
+files is allowed, but ignored.
+
+	<title>{% /.@meta.title %}</title>
+
+Type Checking
+
+A selector that doesn't match the data is normally only found when the
+statement using it runs. Template.TypeCheck finds them ahead of time by
+walking the template with the type of the data instead of a value, following
+with, range, evoke and include the way Execute would, so a test can catch a
+renamed field before a page breaks. The template is compiled first, including
+the files attached with Blocks, and a compilation error is returned as is.
+
+	func TestUserPage(t *testing.T) {
+		err := tmpl.Parse("users/show.tmpl").TypeCheck(reflect.TypeOf(UserPage{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+Any key of a map is allowed, and values of interface types, like the
+arguments of a macro or the values of a map[string]interface{}, aren't checked
+any further.
+
+A Typed template goes a step further, type checking the template when it is
+created and only accepting data of that type in Execute, so a mismatch stops
+the program at startup and a handler can't pass a page the wrong value.
+
+	var showUser = tmpl.MustTyped[UserPage](tmpl.Parse("users/show.tmpl"))
+
+	func show(w http.ResponseWriter, r *http.Request) {
+		showUser.Execute(w, UserPage{User: lookup(r)})
+	}
+
+Checking
+
+Template.Check looks for the problems that don't depend on the data at all:
+evokes of blocks that don't exist, arguments a block doesn't take, calls of
+functions that aren't attached, calls with the wrong number of arguments,
+blocks of the template that are never evoked, and blocks that evoke each other
+in a loop without changing the context. Every block, macro and included file is
+checked, not just the parts the template reaches, but blocks attached from
+files are shared, so they aren't reported as unused.
+
+	if err := tmpl.Parse("users/show.tmpl").Blocks("blocks/*.block").Check(); err != nil {
+		log.Fatal(err)
+	}
+
+Like TypeCheck, Check compiles the template first. Both return CheckErrors, a
+list of every problem found with the file, line and position of each.
+
+Cancellation
+
+Templates executed with ExecuteContext stop as soon as the context is cancelled
+or its deadline passes, checking between statements and between the iterations
+of a range. The context's error is returned from ExecuteContext, so a render for
+a client that has gone away doesn't run to completion.
+
 Modes
 
 Tmpl has two modes, Production and Development, which can be changed at any time
@@ -171,6 +542,37 @@ loaded from disk and compiled in Execute, so that the latest results are always
 used. In Production mode, files are only compiled the first time they are needed
 and the results are cached for subsequent access.
 
+Since compiling normally waits for the first Execute, a broken template would
+only be found by the first request that renders it. Template.Compile compiles
+it right away and returns any error, and MustParse parses, attaches the blocks
+and compiles in one step, panicing on an error so the program doesn't start.
+
+	var showUser = tmpl.MustParse("users/show.tmpl", "blocks/*.block")
+
+Sets
+
+Instead of wiring up every page by hand with Parse and Blocks, a Set loads a
+whole directory, or an fs.FS like an embed.FS, by convention. Every .tmpl file
+is a page named by its path without the extension, and the blocks in every
+.block file are shared by all of the pages as defaults, which a page or the
+layout it extends can replace or build on with super. Functions and built in
+functions are attached once to the set.
+
+	//templates/
+	//	layout.tmpl
+	//	users/show.tmpl
+	//	blocks/nav.block
+	set := tmpl.NewSet("templates").Call("avatar", avatarURL)
+	if err := set.Compile(); err != nil {
+		log.Fatal(err)
+	}
+	err := set.Execute(w, "users/show", context)
+
+A page can be fetched with Lookup to type check it or wrap it in a Typed. A
+block can only be defined by one .block file in the set, and the extensions can
+be changed with Extensions. Files read from an fs.FS reference each other by
+their path in it, with names starting with a slash rooted at its top.
+
 Full Implementation Example
 
 The template, "base.tmpl", defined as,
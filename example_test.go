@@ -1,8 +1,10 @@
 package tmpl_test
 
 import (
+	"context"
 	"github.com/goods/tmpl"
 	"io/ioutil"
+	"time"
 )
 
 var w = ioutil.Discard
@@ -14,6 +16,21 @@ func ExampleCompileMode() {
 	tmpl.CompileMode(tmpl.Development)
 }
 
+func ExampleFuncs() {
+	//attach helpers every template in the application can call
+	tmpl.Funcs(map[string]interface{}{
+		"title": func(s string) string {
+			return "My Site - " + s
+		},
+	})
+
+	//templates can now call title without attaching it
+	t := tmpl.Parse("templates/base.tmpl")
+	if err := t.Execute(w, nil); err != nil {
+		panic(err)
+	}
+}
+
 func ExampleParse() {
 	t := tmpl.Parse("templates/base.tmpl")
 	if err := t.Execute(w, nil); err != nil {
@@ -74,3 +91,38 @@ func ExampleTemplate_Execute() {
 		panic(err)
 	}
 }
+
+func ExampleTemplate_ExecuteContext() {
+	t := tmpl.Parse("templates/base.tmpl")
+
+	//functions that take a context.Context first get the one the template is
+	//executed under
+	t.Call("user", func(ctx context.Context) string {
+		name, _ := ctx.Value("user").(string)
+		return name
+	})
+
+	//give up on the render if it takes longer than a second
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := t.ExecuteContext(ctx, w, nil); err != nil {
+		panic(err)
+	}
+}
+
+func ExampleTemplate_Builtins() {
+	t := tmpl.Parse("templates/base.tmpl")
+
+	//only allow the built in functions for formatting
+	t.Builtins("printf", "upper", "lower")
+
+	//replace the built in json function with our own
+	t.Call("json", func(v interface{}) string {
+		return "{}"
+	})
+
+	if err := t.Execute(w, nil); err != nil {
+		panic(err)
+	}
+}
@@ -53,6 +53,51 @@ func TestExecuteListSubstituteIf(t *testing.T) {
 	}
 }
 
+func TestExecuteSwitchConstantVal(t *testing.T) {
+	var sentinal executer = intValue(2)
+	cases := []*executeSwitch{
+		{intValue(1), []switchCase{{[]valueType{intValue(0)}, nil}, {[]valueType{intValue(2), floatValue(1)}, sentinal}}, nil},
+		{constantValue(`foo`), []switchCase{{[]valueType{constantValue(`foo`)}, sentinal}}, nil},
+		{constantValue(`foo`), []switchCase{{[]valueType{constantValue(`bar`)}, nil}}, sentinal},
+		{intValue(1), []switchCase{{[]valueType{constantValue(`1`)}, nil}}, sentinal},
+	}
+	for _, i := range cases {
+		if e, isConst := i.constValue(); !isConst || e != sentinal {
+			t.Fatal("Expected const sentinal on", i)
+		}
+	}
+
+	//a switch with any selectors can't be known ahead of time
+	sel := &selectorValue{}
+	notConst := []*executeSwitch{
+		{sel, []switchCase{{[]valueType{intValue(0)}, sentinal}}, nil},
+		{intValue(0), []switchCase{{[]valueType{sel}, sentinal}}, nil},
+	}
+	for _, i := range notConst {
+		if _, isConst := i.constValue(); isConst {
+			t.Fatal("Unexpected const on", i)
+		}
+	}
+}
+
+func TestExecuteListSubstituteSwitch(t *testing.T) {
+	var sentinal executer = intValue(2)
+	e := executeList{
+		&executeSwitch{intValue(1), []switchCase{{[]valueType{intValue(1)}, sentinal}}, nil},
+		&executeSwitch{intValue(1), []switchCase{{[]valueType{intValue(0)}, nil}}, nil},
+		&executeSwitch{intValue(1), []switchCase{{[]valueType{intValue(0)}, nil}}, sentinal},
+	}
+	e.compact()
+	if len(e) != 2 {
+		t.Fatalf("Expected 2 got %d", len(e))
+	}
+	for idx, ex := range e {
+		if i, ok := ex.(intValue); !ok || i != sentinal {
+			t.Errorf("item %d fails", idx)
+		}
+	}
+}
+
 func TestExecuteListCombineConstant(t *testing.T) {
 	e := executeList{
 		constantValue(`foo`),
@@ -27,6 +27,20 @@ func (e executeList) Execute(w io.Writer, c *context) (err error) {
 		if ex == nil {
 			return fmt.Errorf("unexpected nil in execute list")
 		}
+		if err = c.done(); err != nil {
+			return
+		}
+
+		//a capture binds its variable for the rest of the list
+		if cp, ok := ex.(*executeCapture); ok {
+			var restore func()
+			if restore, err = cp.capture(c); err != nil {
+				return
+			}
+			defer restore()
+			continue
+		}
+
 		err = ex.Execute(w, c)
 		if err != nil {
 			return
@@ -53,7 +67,20 @@ func (e *executeList) Push(ex executer) {
 	*e = append(*e, ex)
 }
 
+//checkFills returns an error for any fill in the list, which is only allowed
+//directly in the body of an evoke.
+func (e executeList) checkFills() error {
+	for _, ex := range e {
+		if f, ok := ex.(*executeFill); ok {
+			return fmt.Errorf("%d:%d: fill %s outside of an evoke", f.line, f.pos, f.ident)
+		}
+	}
+	return nil
+}
+
 func (e *executeList) compact() {
+	//take switch statements on constants and replace them with their case
+	e.substituteConstSwitch()
 	//take if statements that are always true and replace them
 	e.substituteTrueIf()
 	//take runs of constant expressions and simply them
@@ -62,12 +89,24 @@ func (e *executeList) compact() {
 	e.dropWhitespace()
 }
 
+func (e *executeList) substituteConstSwitch() {
+	for idx, ex := range *e {
+		if eSwitch, ok := ex.(*executeSwitch); ok {
+			//if the case that runs can be known at compile time
+			if val, isConst := eSwitch.constValue(); isConst {
+				(*e)[idx] = scoped(val)
+			}
+		}
+	}
+	//substituteTrueIf drops any nils left behind
+}
+
 func (e *executeList) substituteTrueIf() {
 	for idx, ex := range *e {
 		if eIf, ok := ex.(*executeIf); ok {
 			//if it is a constant if that can be known at compile time
 			if val, isConst := eIf.constValue(); isConst {
-				(*e)[idx] = val
+				(*e)[idx] = scoped(val)
 			}
 		}
 	}
@@ -81,6 +120,17 @@ func (e *executeList) substituteTrueIf() {
 	*e = cl
 	return
 }
+
+//scoped keeps a capture that was the whole body of a statement in a list of
+//its own, so that its variable doesn't outlive the statement once the
+//statement is replaced by its body.
+func scoped(ex executer) executer {
+	if cp, ok := ex.(*executeCapture); ok {
+		return executeList{cp}
+	}
+	return ex
+}
+
 func (e *executeList) combineConstant() {
 	//make a secondary list to copy in folded constants
 	cl := make(executeList, 0, len(*e))
@@ -135,52 +185,547 @@ func (e *executeList) dropWhitespace() {
 // * Execute Block Value *
 // ***********************
 
+//blockMode is how a block definition combines with the definition it
+//overrides.
+type blockMode int
+
+const (
+	blockReplace blockMode = iota
+	blockAppend
+	blockPrepend
+)
+
 type executeBlockValue struct {
-	ident string
-	file  string
-	ex    executer
+	ident  string
+	file   string
+	ex     executer
+	parent *executeBlockValue //the definition this one overrides
+	mode   blockMode
+	params []string //the named arguments it takes, nil if it declares none
+	super  bool     //if it renders the definition it overrides with super
+
+	//if it was attached from a glob, and so can't be replaced by another
+	attached bool
+
+	line, pos int
+}
+
+//checkArgs returns an error if the named arguments don't match the
+//parameters the block declares.
+func (e *executeBlockValue) checkArgs(args []namedArg) error {
+	if len(args) > 0 && e.params == nil {
+		return fmt.Errorf("block %s takes no parameters", e.ident)
+	}
+	for _, arg := range args {
+		if !e.hasParam(arg.name) {
+			return fmt.Errorf("block %s has no parameter %s", e.ident, arg.name)
+		}
+	}
+	return nil
+}
+
+//hasParam returns if the block declares the named parameter.
+func (e *executeBlockValue) hasParam(name string) bool {
+	for _, param := range e.params {
+		if param == name {
+			return true
+		}
+	}
+	return false
 }
 
 func (e *executeBlockValue) Execute(w io.Writer, c *context) (err error) {
+	switch e.mode {
+	case blockAppend:
+		if err = e.executeParent(w, c); err != nil {
+			return
+		}
+		return e.executeOwn(w, c)
+	case blockPrepend:
+		if err = e.executeOwn(w, c); err != nil {
+			return
+		}
+		return e.executeParent(w, c)
+	}
+	return e.executeOwn(w, c)
+}
+
+//executeOwn runs the contents of this definition.
+func (e *executeBlockValue) executeOwn(w io.Writer, c *context) (err error) {
 	if e.ex == nil {
 		return
 	}
+	defer func(block *executeBlockValue) { c.block = block }(c.block)
+	c.block = e
 	return e.ex.Execute(w, c)
 }
 
+//executeParent runs the definition this one overrides, if any.
+func (e *executeBlockValue) executeParent(w io.Writer, c *context) (err error) {
+	if e.parent == nil {
+		return
+	}
+	return e.parent.Execute(w, c)
+}
+
 func (e *executeBlockValue) String() string {
 	return e.ex.String()
 }
 
+// *******************
+// * Execute Capture *
+// *******************
+
+type executeCapture struct {
+	ident string
+	ex    executer
+}
+
+//capture renders the body and binds the output to the variable, returning a
+//function that removes the binding.
+func (e *executeCapture) capture(c *context) (restore func(), err error) {
+	var buf bytes.Buffer
+	if e.ex != nil {
+		if err = e.ex.Execute(&buf, c); err != nil {
+			return
+		}
+	}
+	return c.bind(map[string]interface{}{e.ident: buf.String()}), nil
+}
+
+//Execute runs a capture that has nothing after it to use the variable, so it
+//only needs to render the body for any side effects.
+func (e *executeCapture) Execute(w io.Writer, c *context) error {
+	restore, err := e.capture(c)
+	if err != nil {
+		return err
+	}
+	restore()
+	return nil
+}
+
+func (e *executeCapture) String() string {
+	return fmt.Sprintf("[capture %s] %v", e.ident, e.ex)
+}
+
+// *****************
+// * Execute Macro *
+// *****************
+
+//maxCallDepth is how deep macros can call each other before the execution is
+//stopped, so a macro that never stops calling itself errors instead of
+//overflowing the stack.
+const maxCallDepth = 1000
+
+type executeMacro struct {
+	ident  string
+	file   string
+	params []string
+	ex     executer
+}
+
+//call runs the macro with the arguments of the call, returning its output.
+//The arguments are pushed onto the context as a map keyed by the parameter
+//names, so the output of the caller is still reachable by popping.
+func (e *executeMacro) call(c *context, s callValue) (v interface{}, err error) {
+	if len(s.args) != len(e.params) {
+		return nil, s.errorf("expected %d arguments got %d", len(e.params), len(s.args))
+	}
+	if c.depth >= maxCallDepth {
+		return nil, s.errorf("exceeded maximum call depth of %d", maxCallDepth)
+	}
+	c.depth++
+	defer func() { c.depth-- }()
+	vars := map[string]interface{}{}
+	for i, arg := range s.args {
+		if vars[e.params[i]], err = arg.Value(c); err != nil {
+			return
+		}
+	}
+	if e.ex == nil {
+		return "", nil
+	}
+
+	defer c.setStack(c.stack.dup())
+	c.stack.push(pathItem{name: "(" + e.ident + ")", val: reflect.ValueOf(vars)})
+
+	var buf bytes.Buffer
+	if err = e.ex.Execute(&buf, c); err != nil {
+		return
+	}
+	return buf.String(), nil
+}
+
+func (e *executeMacro) Execute(w io.Writer, c *context) error {
+	return nil
+}
+
+func (e *executeMacro) String() string {
+	return fmt.Sprintf("[macro %s %v] %v", e.ident, e.params, e.ex)
+}
+
+// ****************
+// * Execute Push *
+// ****************
+
+type executePush struct {
+	ident string
+	once  bool
+	ex    executer
+}
+
+func (e *executePush) Execute(w io.Writer, c *context) (err error) {
+	if e.ex == nil {
+		return
+	}
+	var buf bytes.Buffer
+	if err = e.ex.Execute(&buf, c); err != nil {
+		return
+	}
+	c.push(e.ident, buf.Bytes(), e.once)
+	return
+}
+
+func (e *executePush) String() string {
+	return fmt.Sprintf("[push %s] %v", e.ident, e.ex)
+}
+
+// *****************
+// * Execute Stack *
+// *****************
+
+type executeStack struct {
+	ident string
+}
+
+func (e *executeStack) Execute(w io.Writer, c *context) (err error) {
+	//the stack is filled in once everything has had a chance to push to it,
+	//which can only be done for the output of the execution itself
+	if c.out == nil || w != io.Writer(c.out) {
+		return fmt.Errorf("stack %s can't be inside a capture, macro or push", e.ident)
+	}
+	c.out.stack(e.ident)
+	return
+}
+
+func (e *executeStack) String() string {
+	return fmt.Sprintf("[stack %s]", e.ident)
+}
+
+//stack is the content pushed to a stack during an execution.
+type stack struct {
+	items [][]byte
+	seen  map[string]bool
+}
+
+//segment is a piece of held output, either literal data or a reference to
+//the stack that fills it in.
+type segment struct {
+	data  []byte
+	stack string
+	ref   bool
+}
+
+//stackWriter passes writes through to w until a stack is emitted, then holds
+//the rest of the output so the stacks can be filled in when it is flushed.
+type stackWriter struct {
+	w    io.Writer
+	held bool
+	segs []segment
+}
+
+func (s *stackWriter) Write(p []byte) (int, error) {
+	if !s.held {
+		return s.w.Write(p)
+	}
+	if n := len(s.segs); n > 0 && !s.segs[n-1].ref {
+		s.segs[n-1].data = append(s.segs[n-1].data, p...)
+	} else {
+		s.segs = append(s.segs, segment{data: append([]byte(nil), p...)})
+	}
+	return len(p), nil
+}
+
+//stack starts holding the output, leaving a reference to the stack in it.
+func (s *stackWriter) stack(ident string) {
+	s.held = true
+	s.segs = append(s.segs, segment{stack: ident, ref: true})
+}
+
+//flush writes out any held output with the stacks filled in. Stacks nothing
+//was pushed to are empty.
+func (s *stackWriter) flush(stacks map[string]*stack) (err error) {
+	for _, seg := range s.segs {
+		data := seg.data
+		if seg.ref {
+			data = nil
+			if st, ex := stacks[seg.stack]; ex {
+				data = bytes.Join(st.items, nil)
+			}
+		}
+		if len(data) == 0 {
+			continue
+		}
+		if _, err = s.w.Write(data); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// *******************
+// * Execute Include *
+// *******************
+
+type executeInclude struct {
+	name   string //the file as written
+	abs    string //the file relative to the file including it
+	ctx    *selectorValue
+	ignore bool
+
+	line, pos int
+}
+
+func (e *executeInclude) Execute(w io.Writer, c *context) (err error) {
+	tree := c.includes[e.abs]
+	if tree == nil {
+		if e.ignore {
+			return
+		}
+		return fmt.Errorf("%d:%d: include %q not loaded", e.line, e.pos, e.name)
+	}
+	if tree.base == nil {
+		return
+	}
+
+	//set up our context
+	if e.ctx != nil {
+		defer c.setStack(c.stack.dup())
+		if err = c.cd(e.ctx); err != nil {
+			return
+		}
+	}
+
+	return tree.base.Execute(w, c)
+}
+
+func (e *executeInclude) String() string {
+	return fmt.Sprintf("[include %q %v]", e.name, e.ctx)
+}
+
+// *****************
+// * Execute Super *
+// *****************
+
+type executeSuper struct {
+	line, pos int
+}
+
+func (e *executeSuper) Execute(w io.Writer, c *context) (err error) {
+	switch {
+	case c.block == nil:
+		return fmt.Errorf("%d:%d: super outside of a block", e.line, e.pos)
+	case c.block.parent == nil:
+		return fmt.Errorf("%d:%d: block %s from %q doesn't override a definition", e.line, e.pos, c.block.ident, c.block.file)
+	}
+	return c.block.parent.Execute(w, c)
+}
+
+func (e *executeSuper) String() string {
+	return "[super]"
+}
+
 // *****************
 // * Execute Evoke *
 // *****************
 
+//namedArg is an argument passed to a block by name.
+type namedArg struct {
+	name string
+	val  valueType
+}
+
 type executeEvoke struct {
-	ident string
-	ctx   *selectorValue
+	ident    string
+	name     valueType //picks the block at runtime if there is no ident
+	optional bool      //renders nothing if the block doesn't exist
+	ctx      *selectorValue
+	args     []namedArg
+	def      bool //renders a block where it is defined
+
+	//the body passed to the block, and the named slots filled in it
+	body  executer
+	fills map[string]executer
+
+	line, pos int
+}
+
+//setBody sets the body of the evoke, pulling the fills out as named slots.
+func (e *executeEvoke) setBody(l executeList) error {
+	body := executeList{}
+	e.fills = map[string]executer{}
+	for _, ex := range l {
+		f, ok := ex.(*executeFill)
+		if !ok {
+			body = append(body, ex)
+			continue
+		}
+		if _, ex := e.fills[f.ident]; ex {
+			return fmt.Errorf("%d:%d: slot %s filled twice", f.line, f.pos, f.ident)
+		}
+		e.fills[f.ident] = f.ex
+	}
+	body.compact()
+	switch len(body) {
+	case 0:
+	case 1:
+		e.body = body[0]
+	default:
+		e.body = body
+	}
+	return nil
 }
 
 func (e *executeEvoke) Execute(w io.Writer, c *context) (err error) {
+	ident := e.ident
+	if e.name != nil {
+		if ident, err = e.blockName(c); err != nil {
+			return
+		}
+	}
+
 	//ask the context for the most up to date executer
-	ex := c.getBlock(e.ident)
+	ex := c.getBlock(ident)
 	if ex == nil {
-		return fmt.Errorf("No block by the name %s", e.ident)
+		if e.optional {
+			return
+		}
+		return fmt.Errorf("No block by the name %s", ident)
+	}
+	if err = ex.checkArgs(e.args); err != nil {
+		return
+	}
+
+	//grab the arguments before the context changes
+	vars := map[string]interface{}{}
+	for _, param := range ex.params {
+		vars[param] = nil
+	}
+	for _, arg := range e.args {
+		if vars[arg.name], err = arg.val.Value(c); err != nil {
+			return
+		}
 	}
 
 	//set up our context
+	caller := c.stack.dup()
 	if e.ctx != nil {
 		defer c.setStack(c.stack.dup())
 		if err = c.cd(e.ctx); err != nil {
 			return
 		}
 	}
+	if len(vars) > 0 {
+		defer c.bind(vars)()
+	}
+
+	//hand the block our body to render in our context
+	defer func(slots *slotFrame) { c.slots = slots }(c.slots)
+	c.slots = &slotFrame{
+		body:   e.body,
+		fills:  e.fills,
+		stack:  caller,
+		block:  c.block,
+		parent: c.slots,
+	}
 
 	return ex.Execute(w, c)
 }
 
+//blockName evaluates the value that picks the block to evoke.
+func (e *executeEvoke) blockName(c *context) (string, error) {
+	val, err := e.name.Value(c)
+	if err != nil {
+		return "", err
+	}
+	//any string type works, like the kinds of an enum
+	rv := indirect(reflect.ValueOf(val))
+	if rv.Kind() != reflect.String {
+		return "", fmt.Errorf("%d:%d: block name must be a string, got %T", e.line, e.pos, val)
+	}
+	return rv.String(), nil
+}
+
 func (e *executeEvoke) String() string {
-	return fmt.Sprintf("[block %s %v]", e.ident, e.ctx)
+	var buf bytes.Buffer
+	if e.name != nil {
+		fmt.Fprintf(&buf, "[block %v %v", e.name, e.ctx)
+	} else {
+		fmt.Fprintf(&buf, "[block %s %v", e.ident, e.ctx)
+	}
+	for _, arg := range e.args {
+		fmt.Fprintf(&buf, " %s=%v", arg.name, arg.val)
+	}
+	fmt.Fprint(&buf, "]")
+	return buf.String()
+}
+
+// ****************
+// * Execute Slot *
+// ****************
+
+//slotFrame is the body an evoke passes to a block, along with what is needed
+//to render it as if it were where the evoke is.
+type slotFrame struct {
+	body   executer
+	fills  map[string]executer
+	stack  path
+	block  *executeBlockValue
+	parent *slotFrame
+}
+
+type executeSlot struct {
+	ident string
+}
+
+func (e *executeSlot) Execute(w io.Writer, c *context) (err error) {
+	frame := c.slots
+	if frame == nil {
+		return
+	}
+	ex := frame.body
+	if e.ident != "" {
+		ex = frame.fills[e.ident]
+	}
+	if ex == nil {
+		return
+	}
+
+	//render it in the context of the evoke
+	defer func(stack path, block *executeBlockValue) {
+		c.stack, c.slots, c.block = stack, frame, block
+	}(c.stack, c.block)
+	c.stack, c.slots, c.block = frame.stack.dup(), frame.parent, frame.block
+	return ex.Execute(w, c)
+}
+
+func (e *executeSlot) String() string {
+	return fmt.Sprintf("[slot %s]", e.ident)
+}
+
+//executeFill is the content for a named slot in the body of an evoke.
+type executeFill struct {
+	ident     string
+	ex        executer
+	line, pos int
+}
+
+func (e *executeFill) Execute(w io.Writer, c *context) error {
+	return fmt.Errorf("%d:%d: fill %s outside of an evoke", e.line, e.pos, e.ident)
+}
+
+func (e *executeFill) String() string {
+	return fmt.Sprintf("[fill %s] %v", e.ident, e.ex)
 }
 
 // ****************
@@ -269,18 +814,28 @@ func (e *executeRange) String() string {
 
 func (e *executeRange) rangeMap(w io.Writer, c *context, v reflect.Value, kstr, vstr string) (err error) {
 	for _, key := range v.MapKeys() {
+		if err = c.done(); err != nil {
+			return
+		}
 		c.setAt(kstr, indirect(key).Interface())
 		c.setAt(vstr, indirect(v.MapIndex(key)).Interface())
-		err = e.ex.Execute(w, c)
+		if err = e.ex.Execute(w, c); err != nil {
+			return
+		}
 	}
 	return
 }
 
 func (e *executeRange) rangeSlice(w io.Writer, c *context, v reflect.Value, kstr, vstr string) (err error) {
 	for i := 0; i < v.Len(); i++ {
+		if err = c.done(); err != nil {
+			return
+		}
 		c.setAt(kstr, i)
 		c.setAt(vstr, indirect(v.Index(i)).Interface())
-		err = e.ex.Execute(w, c)
+		if err = e.ex.Execute(w, c); err != nil {
+			return
+		}
 	}
 	return
 }
@@ -288,9 +843,14 @@ func (e *executeRange) rangeSlice(w io.Writer, c *context, v reflect.Value, kstr
 func (e *executeRange) rangeStruct(w io.Writer, c *context, v reflect.Value, kstr, vstr string) (err error) {
 	typ := v.Type()
 	for i := 0; i < v.NumField(); i++ {
+		if err = c.done(); err != nil {
+			return
+		}
 		c.setAt(kstr, typ.Field(i).Name)
 		c.setAt(vstr, indirect(v.Field(i)).Interface())
-		err = e.ex.Execute(w, c)
+		if err = e.ex.Execute(w, c); err != nil {
+			return
+		}
 	}
 	return
 }
@@ -327,6 +887,9 @@ func (e *executeIf) constValue() (ex executer, isConst bool) {
 
 func (e *executeIf) Execute(w io.Writer, c *context) (err error) {
 	v, err := e.cond.Value(c)
+	if isFatal(err) {
+		return err
+	}
 	if err == nil {
 		t := truthy(v)
 		if t {
@@ -346,6 +909,87 @@ func (e *executeIf) String() string {
 	return fmt.Sprintf("[if %s] %v", e.cond, e.succ)
 }
 
+// ******************
+// * Execute Switch *
+// ******************
+
+type switchCase struct {
+	vals []valueType
+	ex   executer
+}
+
+type executeSwitch struct {
+	val   valueType
+	cases []switchCase
+	def   executer
+}
+
+func (e *executeSwitch) constValue() (ex executer, isConst bool) {
+	if !isConstantValue(e.val) {
+		return
+	}
+	for _, cs := range e.cases {
+		for _, v := range cs.vals {
+			if !isConstantValue(v) {
+				return
+			}
+		}
+	}
+
+	//constants never error getting their value
+	v, _ := e.val.Value(nil)
+	for _, cs := range e.cases {
+		for _, cv := range cs.vals {
+			if c, _ := cv.Value(nil); equal(v, c) {
+				return cs.ex, true
+			}
+		}
+	}
+	return e.def, true
+}
+
+func (e *executeSwitch) Execute(w io.Writer, c *context) (err error) {
+	//like an if, a value that can't be found matches nothing, but a failed
+	//call or operator stops the execution
+	v, err := e.val.Value(c)
+	if isFatal(err) {
+		return err
+	}
+	if err == nil {
+		for _, cs := range e.cases {
+			for _, cv := range cs.vals {
+				val, err := cv.Value(c)
+				if isFatal(err) {
+					return err
+				}
+				if err != nil || !equal(v, val) {
+					continue
+				}
+				if cs.ex == nil {
+					return nil
+				}
+				return cs.ex.Execute(w, c)
+			}
+		}
+	}
+	if e.def != nil {
+		return e.def.Execute(w, c)
+	}
+	return nil
+}
+
+func (e *executeSwitch) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "[switch %s]", e.val)
+	for _, cs := range e.cases {
+		fmt.Fprintf(&buf, " | %v %v", cs.vals, cs.ex)
+	}
+	if e.def != nil {
+		fmt.Fprintf(&buf, " | default %v", e.def)
+	}
+	return buf.String()
+}
+
 // truthy returns whether the value is 'true', in the sense of not the zero of its type,
 // and whether the value has a meaningful truth value.
 func truthy(i interface{}) (truth bool) {
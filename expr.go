@@ -0,0 +1,314 @@
+package tmpl
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+)
+
+//precedence is how tightly each binary operator binds. Concatenation binds
+//the loosest so that `.a + 1 ~ "px"` adds before it joins.
+var precedence = map[string]int{
+	"~": 1,
+	"+": 2, "-": 2,
+	"*": 3, "/": 3, "%": 3,
+}
+
+//exprError is the error for an operator that can't be applied to its values.
+//Like a callError, it always aborts the execution.
+type exprError struct {
+	line, pos int
+	err       error
+}
+
+func (e *exprError) Error() string {
+	return fmt.Sprintf("%d:%d: %v", e.line, e.pos, e.err)
+}
+
+//Unwrap returns the reason the operator failed.
+func (e *exprError) Unwrap() error {
+	return e.err
+}
+
+// *******************
+// * Parsing Helpers *
+// *******************
+
+//consumeBinary consumes a value joined with any binary operators that bind at
+//least as tightly as prec.
+func consumeBinary(p *parser, prec int) (valueType, error) {
+	left, err := consumeUnary(p)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if op.typ != tokenOperator || precedence[string(op.dat)] < prec {
+			return left, nil
+		}
+		p.next()
+
+		right, err := consumeBinary(p, precedence[string(op.dat)]+1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryValue{string(op.dat), left, right, op.line, op.pos}
+		if left, err = foldConstant(left); err != nil {
+			return nil, err
+		}
+	}
+}
+
+//consumeUnary consumes a value with an optional leading minus.
+func consumeUnary(p *parser) (valueType, error) {
+	op := p.next()
+	if op.typ != tokenOperator {
+		p.backup()
+		return consumeOperand(p)
+	}
+	if string(op.dat) != "-" {
+		return nil, fmt.Errorf("%d:%d: unexpected operator %s", op.line, op.pos, op.dat)
+	}
+	val, err := consumeUnary(p)
+	if err != nil {
+		return nil, err
+	}
+	return foldConstant(&negValue{val, op.line, op.pos})
+}
+
+//foldConstant replaces an operator on constants with its result.
+func foldConstant(v valueType) (valueType, error) {
+	switch e := v.(type) {
+	case *binaryValue:
+		if !isConstantValue(e.left) || !isConstantValue(e.right) {
+			return v, nil
+		}
+	case *negValue:
+		if !isConstantValue(e.val) {
+			return v, nil
+		}
+	}
+
+	//constants never need a context
+	res, err := v.Value(nil)
+	if err != nil {
+		return nil, err
+	}
+	switch r := res.(type) {
+	case int64:
+		return intValue(r), nil
+	case float64:
+		return floatValue(r), nil
+	case string:
+		return constantValue(r), nil
+	}
+	return v, nil
+}
+
+// ****************
+// * Binary Value *
+// ****************
+
+type binaryValue struct {
+	op          string
+	left, right valueType
+	line, pos   int
+}
+
+func (b *binaryValue) Value(c *context) (v interface{}, err error) {
+	l, err := b.left.Value(c)
+	if err != nil {
+		return
+	}
+	r, err := b.right.Value(c)
+	if err != nil {
+		return
+	}
+	if v, err = operate(b.op, l, r); err != nil {
+		err = &exprError{b.line, b.pos, err}
+	}
+	return
+}
+
+func (b *binaryValue) Execute(w io.Writer, c *context) (err error) {
+	val, err := b.Value(c)
+	if err != nil {
+		return
+	}
+	err = writeValue(w, val)
+	return
+}
+
+func (b *binaryValue) String() string {
+	return fmt.Sprintf("[%s %v %v]", b.op, b.left, b.right)
+}
+
+// *************
+// * Neg Value *
+// *************
+
+type negValue struct {
+	val       valueType
+	line, pos int
+}
+
+func (n *negValue) Value(c *context) (v interface{}, err error) {
+	val, err := n.val.Value(c)
+	if err != nil {
+		return
+	}
+	if v, err = negate(val); err != nil {
+		err = &exprError{n.line, n.pos, err}
+	}
+	return
+}
+
+func (n *negValue) Execute(w io.Writer, c *context) (err error) {
+	val, err := n.Value(c)
+	if err != nil {
+		return
+	}
+	err = writeValue(w, val)
+	return
+}
+
+func (n *negValue) String() string {
+	return fmt.Sprintf("[- %v]", n.val)
+}
+
+// **************
+// * Arithmetic *
+// **************
+
+//operate applies the binary operator to the values. Concatenation works on
+//anything, formatting it like it would be printed. Strings can be added
+//together, and numbers follow the rules of Go: values of the same type give a
+//value of that type, and mixed types are promoted to a float64 if either is a
+//float, a uint64 if both are unsigned, or an int64 otherwise.
+func operate(op string, a, b interface{}) (interface{}, error) {
+	if op == "~" {
+		return fmt.Sprint(a) + fmt.Sprint(b), nil
+	}
+
+	av, bv := indirect(reflect.ValueOf(a)), indirect(reflect.ValueOf(b))
+	if !av.IsValid() || !bv.IsValid() {
+		return nil, fmt.Errorf("invalid operation: %v %s %v", a, op, b)
+	}
+	switch ak, bk := av.Kind(), bv.Kind(); {
+	case ak == reflect.String && bk == reflect.String:
+		if op != "+" {
+			return nil, fmt.Errorf("invalid operation: operator %s not defined on strings", op)
+		}
+		if av.Type() == bv.Type() {
+			return reflect.ValueOf(av.String() + bv.String()).Convert(av.Type()).Interface(), nil
+		}
+		return av.String() + bv.String(), nil
+	case isNumericKind(ak) && isNumericKind(bk):
+		res, err := numericOp(op, av, bv)
+		if err != nil {
+			return nil, err
+		}
+		if av.Type() == bv.Type() {
+			return res.Convert(av.Type()).Interface(), nil
+		}
+		return res.Interface(), nil
+	}
+	return nil, fmt.Errorf("invalid operation: %v %s %v (mismatched types %v and %v)", a, op, b, av.Type(), bv.Type())
+}
+
+//numericOp applies the operator to the numbers, returning an int64, uint64 or
+//float64 value.
+func numericOp(op string, a, b reflect.Value) (reflect.Value, error) {
+	divZero := fmt.Errorf("division by zero")
+	switch ak, bk := a.Kind(), b.Kind(); {
+	case isFloatKind(ak) || isFloatKind(bk):
+		x, y := toFloat(a), toFloat(b)
+		switch op {
+		case "+":
+			return reflect.ValueOf(x + y), nil
+		case "-":
+			return reflect.ValueOf(x - y), nil
+		case "*":
+			return reflect.ValueOf(x * y), nil
+		case "/":
+			if y == 0 {
+				return reflect.Value{}, divZero
+			}
+			return reflect.ValueOf(x / y), nil
+		}
+		return reflect.Value{}, fmt.Errorf("invalid operation: operator %s not defined on floats", op)
+
+	case isUintKind(ak) && isUintKind(bk):
+		x, y := a.Uint(), b.Uint()
+		switch op {
+		case "+":
+			return reflect.ValueOf(x + y), nil
+		case "-":
+			return reflect.ValueOf(x - y), nil
+		case "*":
+			return reflect.ValueOf(x * y), nil
+		}
+		if y == 0 {
+			return reflect.Value{}, divZero
+		}
+		if op == "/" {
+			return reflect.ValueOf(x / y), nil
+		}
+		return reflect.ValueOf(x % y), nil
+	}
+
+	x, err := toInt(a)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	y, err := toInt(b)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	switch op {
+	case "+":
+		return reflect.ValueOf(x + y), nil
+	case "-":
+		return reflect.ValueOf(x - y), nil
+	case "*":
+		return reflect.ValueOf(x * y), nil
+	}
+	if y == 0 {
+		return reflect.Value{}, divZero
+	}
+	if op == "/" {
+		return reflect.ValueOf(x / y), nil
+	}
+	return reflect.ValueOf(x % y), nil
+}
+
+//toInt returns the value of an integer reflect value as an int64.
+func toInt(v reflect.Value) (int64, error) {
+	if isUintKind(v.Kind()) {
+		if v.Uint() > math.MaxInt64 {
+			return 0, fmt.Errorf("%v overflows int64", v.Uint())
+		}
+		return int64(v.Uint()), nil
+	}
+	return v.Int(), nil
+}
+
+//negate returns the negative of a number, keeping its type unless it is
+//unsigned, in which case it becomes an int64.
+func negate(val interface{}) (interface{}, error) {
+	v := indirect(reflect.ValueOf(val))
+	switch k := v.Kind(); {
+	case isIntKind(k):
+		return reflect.ValueOf(-v.Int()).Convert(v.Type()).Interface(), nil
+	case isFloatKind(k):
+		return reflect.ValueOf(-v.Float()).Convert(v.Type()).Interface(), nil
+	case isUintKind(k):
+		i, err := toInt(v)
+		if err != nil {
+			return nil, err
+		}
+		return -i, nil
+	}
+	return nil, fmt.Errorf("invalid operation: -%v (%T is not a number)", val, val)
+}
@@ -0,0 +1,59 @@
+package tmpl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExprConstantFold(t *testing.T) {
+	cases := []struct {
+		code string
+		ex   valueType
+	}{
+		{`{% 1 + 2 * 3 %}`, intValue(7)},
+		{`{% -(1 - 3) %}`, intValue(2)},
+		{`{% 1 / 2.0 %}`, floatValue(0.5)},
+		{`{% "a" ~ 1 + 1 %}`, constantValue("a2")},
+	}
+	for _, c := range cases {
+		tree, err := parse(lex([]byte(c.code)))
+		if err != nil {
+			t.Errorf("%s: %v", c.code, err)
+			continue
+		}
+		if !reflect.DeepEqual(tree.base, c.ex) {
+			t.Errorf("%s: Expected %v got %v", c.code, c.ex, tree.base)
+		}
+	}
+
+	//anything with a selector waits for the execution
+	tree, err := parse(lex([]byte(`{% 1 + .a %}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tree.base.(*binaryValue); !ok {
+		t.Errorf("Expected a binary value got %v", tree.base)
+	}
+}
+
+func TestExprErrorPositions(t *testing.T) {
+	if _, err := parse(lex([]byte("{% 1 / 0 %}"))); err == nil || !strings.Contains(err.Error(), "0:5: division by zero") {
+		t.Errorf("Expected a division by zero at 0:5 got %v", err)
+	}
+
+	tree, err := parse(lex([]byte(`ab{% .a + .b %}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tree.Execute(new(strings.Builder), d{"a": 1, "b": "x"})
+	if err == nil || !strings.Contains(err.Error(), "0:8: invalid operation") {
+		t.Errorf("Expected an invalid operation at 0:8 got %v", err)
+	}
+
+	//the messages keep any percent signs in them
+	_, err = parse(lex([]byte("{% 1.5 % 2 %}")))
+	if err == nil || !strings.Contains(err.Error(), "operator % not defined on floats") {
+		t.Errorf("Expected the operator in the message got %v", err)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -148,3 +150,455 @@ func TestFilesTemplateWithBothBlocks(t *testing.T) {
 		t.Fatal("Expected error with no block definition")
 	}
 }
+
+func TestFilesTemplateDefaultBlocks(t *testing.T) {
+	dir := createTestDir(t, []templateFile{
+		{"base.tmpl", `<{% block title %}default{% end block %}>`},
+		{"title.block", `{% block title %}attached{% end block %}`},
+		{"temp.block", `{% block title %}temporary{% end block %}`},
+	})
+	defer os.RemoveAll(dir)
+
+	j := func(path string) string {
+		return filepath.Join(dir, path)
+	}
+
+	cases := []struct {
+		tmpl  *Template
+		globs []string
+		exp   string
+	}{
+		{Parse(j("base.tmpl")), nil, "<default>"},
+		{Parse(j("base.tmpl")).Blocks(j("title.block")), nil, "<attached>"},
+		{Parse(j("base.tmpl")), []string{j("temp.block")}, "<temporary>"},
+	}
+
+	for id, c := range cases {
+		for i := 0; i < 3; i++ {
+			var buf bytes.Buffer
+			if err := c.tmpl.Execute(&buf, nil, c.globs...); err != nil {
+				t.Fatalf("%d: %v", id, err)
+			}
+			if got := buf.String(); got != c.exp {
+				t.Fatalf("%d:\nExp %q\nGot %q", id, c.exp, got)
+			}
+		}
+	}
+
+	//ensure the temporary block doesn't stay
+	tmp := Parse(j("base.tmpl"))
+	if err := tmp.Execute(ioutil.Discard, nil, j("temp.block")); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tmp.Execute(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "<default>" {
+		t.Fatalf("\nExp %q\nGot %q", "<default>", got)
+	}
+
+	//attached files still can't replace each other
+	tmp = Parse(j("base.tmpl")).Blocks(j("title.block"))
+	if err := tmp.Execute(ioutil.Discard, nil, j("temp.block")); err == nil {
+		t.Fatal("Expected an error redefining an attached block")
+	}
+
+	//even when matched by the same glob
+	tmp = Parse(j("base.tmpl")).Blocks(j("t*.block"))
+	if err := tmp.Execute(ioutil.Discard, nil); err == nil {
+		t.Fatal("Expected an error redefining an attached block")
+	}
+}
+
+func TestFilesTemplateExtends(t *testing.T) {
+	dir := createTestDir(t, []templateFile{
+		{"layouts/site.tmpl", `<{% block title %}site{% end block %}|{% block nav %}nav{% end block %}|{% block content %}{% end block %}>`},
+		{"layouts/section.tmpl", `{% extends "site.tmpl" %}{% block nav %}section nav{% end block %}`},
+		{"pages/page.tmpl", `{% extends "../layouts/section.tmpl" %}ignored{% block content %}{% .%}{% end block %}`},
+		{"pages/titled.tmpl", `{% extends "page.tmpl" %}{% block title %}titled{% end block %}`},
+		{"title.block", `{% block title %}attached{% end block %}`},
+		{"cycle/a.tmpl", `{% extends "b.tmpl" %}`},
+		{"cycle/b.tmpl", `{% extends "a.tmpl" %}`},
+		{"cycle/self.tmpl", `{% extends "self.tmpl" %}`},
+		{"missing.tmpl", `{% extends "nope.tmpl" %}`},
+		{"extends.block", `{% extends "layouts/site.tmpl" %}`},
+	})
+	defer os.RemoveAll(dir)
+
+	j := func(path string) string {
+		return filepath.Join(dir, path)
+	}
+
+	cases := []struct {
+		tmpl *Template
+		exp  string
+	}{
+		{Parse(j("layouts/site.tmpl")), "<site|nav|>"},
+		{Parse(j("layouts/section.tmpl")), "<site|section nav|>"},
+		{Parse(j("pages/page.tmpl")), "<site|section nav|page>"},
+		{Parse(j("pages/titled.tmpl")), "<titled|section nav|page>"},
+		{Parse(j("pages/titled.tmpl")).Blocks(j("title.block")), "<attached|section nav|page>"},
+	}
+	for id, c := range cases {
+		var buf bytes.Buffer
+		if err := c.tmpl.Execute(&buf, "page"); err != nil {
+			t.Errorf("%d: %v", id, err)
+			continue
+		}
+		if got := buf.String(); got != c.exp {
+			t.Errorf("%d:\nExp %q\nGot %q", id, c.exp, got)
+		}
+	}
+
+	for _, tmp := range []*Template{
+		Parse(j("cycle/a.tmpl")),
+		Parse(j("cycle/self.tmpl")),
+		Parse(j("missing.tmpl")),
+		Parse(j("layouts/site.tmpl")).Blocks(j("extends.block")),
+	} {
+		if err := tmp.Execute(ioutil.Discard, nil); err == nil {
+			t.Errorf("%s: Expected an error", tmp.base)
+		}
+	}
+
+	err := Parse(j("cycle/a.tmpl")).Execute(ioutil.Discard, nil)
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("Expected a cycle error got %v", err)
+	}
+}
+
+func TestFilesTemplateSuper(t *testing.T) {
+	dir := createTestDir(t, []templateFile{
+		{"site.tmpl", `<{% block head %}site{% end block %}>`},
+		{"section.tmpl", `{% extends "site.tmpl" %}{% block head %}{% super %}+section{% end block %}`},
+		{"page.tmpl", `{% extends "section.tmpl" %}{% block head %}{% super %}+page{% end block %}`},
+		{"head.block", `{% block head %}{% super %}+attached{% end block %}`},
+		{"temp.block", `{% block head %}[{% super %}]{% end block %}`},
+	})
+	defer os.RemoveAll(dir)
+
+	j := func(path string) string {
+		return filepath.Join(dir, path)
+	}
+
+	cases := []struct {
+		tmpl  *Template
+		globs []string
+		exp   string
+	}{
+		{Parse(j("section.tmpl")), nil, "<site+section>"},
+		{Parse(j("page.tmpl")), nil, "<site+section+page>"},
+		{Parse(j("page.tmpl")).Blocks(j("head.block")), nil, "<site+section+page+attached>"},
+		{Parse(j("page.tmpl")).Blocks(j("head.block")), []string{j("temp.block")}, "<[site+section+page+attached]>"},
+		{Parse(j("site.tmpl")), []string{j("temp.block")}, "<[site]>"},
+	}
+	for id, c := range cases {
+		for i := 0; i < 2; i++ {
+			var buf bytes.Buffer
+			if err := c.tmpl.Execute(&buf, nil, c.globs...); err != nil {
+				t.Fatalf("%d: %v", id, err)
+			}
+			if got := buf.String(); got != c.exp {
+				t.Fatalf("%d:\nExp %q\nGot %q", id, c.exp, got)
+			}
+		}
+	}
+
+}
+
+func TestFilesTemplateAppendPrepend(t *testing.T) {
+	dir := createTestDir(t, []templateFile{
+		{"site.tmpl", `<{% block scripts %}site{% end block %}>`},
+		{"append.tmpl", `{% extends "site.tmpl" %}{% block scripts append %}+page{% end block %}`},
+		{"prepend.tmpl", `{% extends "site.tmpl" %}{% block scripts prepend %}page+{% end block %}`},
+		{"append.block", `{% block scripts append %}+attached{% end block %}`},
+		{"replace.block", `{% block scripts %}replaced{% end block %}`},
+	})
+	defer os.RemoveAll(dir)
+
+	j := func(path string) string {
+		return filepath.Join(dir, path)
+	}
+
+	cases := []struct {
+		tmpl  *Template
+		globs []string
+		exp   string
+	}{
+		{Parse(j("append.tmpl")), nil, "<site+page>"},
+		{Parse(j("prepend.tmpl")), nil, "<page+site>"},
+		{Parse(j("append.tmpl")).Blocks(j("append.block")), nil, "<site+page+attached>"},
+		{Parse(j("prepend.tmpl")), []string{j("append.block")}, "<page+site+attached>"},
+		{Parse(j("append.tmpl")).Blocks(j("replace.block")), []string{j("append.block")}, "<replaced+attached>"},
+		{Parse(j("site.tmpl")), []string{j("append.block")}, "<site+attached>"},
+	}
+	for id, c := range cases {
+		for i := 0; i < 2; i++ {
+			var buf bytes.Buffer
+			if err := c.tmpl.Execute(&buf, nil, c.globs...); err != nil {
+				t.Fatalf("%d: %v", id, err)
+			}
+			if got := buf.String(); got != c.exp {
+				t.Fatalf("%d:\nExp %q\nGot %q", id, c.exp, got)
+			}
+		}
+	}
+}
+
+func TestFilesTemplateInclude(t *testing.T) {
+	dir := createTestDir(t, []templateFile{
+		{"page.tmpl", `<{% include "partials/nav.tmpl" .Nav %}>`},
+		{"partials/nav.tmpl", `{% range . as _ v %}[{% include "item.tmpl" .v %}]{% end range %}`},
+		{"partials/item.tmpl", `{% .Name %}`},
+		{"blocks.tmpl", `{% include "partials/defs.tmpl" %}{% evoke greet %}`},
+		{"partials/defs.tmpl", `{% block greet %}{% if .Name %}hi {% .Name %}{% end if %}{% end block %}`},
+		{"override.tmpl", `{% include "partials/defs.tmpl" %}|{% block greet %}yo{% end block %}`},
+		{"missing.tmpl", `<{% include "nope.tmpl" ignore missing %}>`},
+		{"attached.block", `{% block greet %}{% include "partials/item.tmpl" %}{% end block %}`},
+		{"base.tmpl", `<{% block content %}{% end block %}>`},
+		{"extends.tmpl", `{% extends "base.tmpl" %}{% block content %}{% include "partials/item.tmpl" %}{% end block %}`},
+	})
+	defer os.RemoveAll(dir)
+
+	j := func(path string) string {
+		return filepath.Join(dir, path)
+	}
+
+	data := d{"Name": "page", "Nav": []d{{"Name": "a"}, {"Name": "b"}}}
+	cases := []struct {
+		tmpl  *Template
+		globs []string
+		exp   string
+	}{
+		{Parse(j("page.tmpl")), nil, "<[a][b]>"},
+		{Parse(j("blocks.tmpl")), nil, "hi pagehi page"},
+		{Parse(j("override.tmpl")), nil, "yo|yo"},
+		{Parse(j("missing.tmpl")), nil, "<>"},
+		{Parse(j("blocks.tmpl")), []string{j("attached.block")}, "pagepage"},
+		{Parse(j("extends.tmpl")), nil, "<page>"},
+	}
+	for id, c := range cases {
+		for i := 0; i < 2; i++ {
+			var buf bytes.Buffer
+			if err := c.tmpl.Execute(&buf, data, c.globs...); err != nil {
+				t.Fatalf("%d: %v", id, err)
+			}
+			if got := buf.String(); got != c.exp {
+				t.Fatalf("%d:\nExp %q\nGot %q", id, c.exp, got)
+			}
+		}
+	}
+
+	//ensure the files included by the temporary blocks don't stay
+	tmp := Parse(j("blocks.tmpl"))
+	if err := tmp.Execute(ioutil.Discard, data, j("attached.block")); err != nil {
+		t.Fatal(err)
+	}
+	item, err := filepath.Abs(j("partials/item.tmpl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ex := tmp.tree.context.includes[item]; ex {
+		t.Fatal("include from a temporary block stayed after the execution")
+	}
+}
+
+func TestFilesTemplateIncludeFailures(t *testing.T) {
+	dir := createTestDir(t, []templateFile{
+		{"self.tmpl", `{% include "self.tmpl" %}`},
+		{"a.tmpl", `{% include "b.tmpl" %}`},
+		{"b.tmpl", `{% include "a.tmpl" %}`},
+		{"missing.tmpl", `{% include "nope.tmpl" %}`},
+		{"base.tmpl", `base`},
+		{"extends.tmpl", `{% include "child.tmpl" %}`},
+		{"child.tmpl", `{% extends "base.tmpl" %}`},
+	})
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		file string
+		msg  string
+	}{
+		{"self.tmpl", "include cycle"},
+		{"a.tmpl", "include cycle"},
+		{"missing.tmpl", "nope.tmpl"},
+		{"extends.tmpl", "can't extend"},
+	}
+	for id, c := range cases {
+		err := Parse(filepath.Join(dir, c.file)).Execute(ioutil.Discard, nil)
+		if err == nil || !strings.Contains(err.Error(), c.msg) {
+			t.Errorf("%d: expected an error containing %q, got %v", id, c.msg, err)
+		}
+	}
+}
+
+func TestFilesTemplateMacros(t *testing.T) {
+	dir := createTestDir(t, []templateFile{
+		{"page.tmpl", `{% call price .Price "USD" %}`},
+		{"base.tmpl", `{% macro price a c %}{% .a %}{% end macro %}<{% block content %}{% end block %}>`},
+		{"child.tmpl", `{% extends "base.tmpl" %}{% block content %}{% call price .Price "USD" %}{% end block %}`},
+		{"include.tmpl", `{% include "money.block" %}{% call price .Price "USD" %}`},
+		{"money.block", `{% macro price a c %}{% .c %}{% .a %}{% end macro %}`},
+		{"euro.block", `{% macro price a c %}{% .a %} EUR{% end macro %}`},
+	})
+	defer os.RemoveAll(dir)
+
+	j := func(path string) string {
+		return filepath.Join(dir, path)
+	}
+
+	data := d{"Price": 5}
+	cases := []struct {
+		tmpl  *Template
+		globs []string
+		exp   string
+	}{
+		{Parse(j("page.tmpl")).Blocks(j("money.block")), nil, "USD5"},
+		{Parse(j("page.tmpl")), []string{j("money.block")}, "USD5"},
+		{Parse(j("page.tmpl")).Blocks(j("money.block")), []string{j("euro.block")}, "5 EUR"},
+		{Parse(j("child.tmpl")), nil, "<5>"},
+		{Parse(j("child.tmpl")).Blocks(j("money.block")), nil, "<USD5>"},
+		{Parse(j("include.tmpl")), nil, "USD5"},
+	}
+	for id, c := range cases {
+		for i := 0; i < 2; i++ {
+			var buf bytes.Buffer
+			if err := c.tmpl.Execute(&buf, data, c.globs...); err != nil {
+				t.Fatalf("%d: %v", id, err)
+			}
+			if got := buf.String(); got != c.exp {
+				t.Fatalf("%d:\nExp %q\nGot %q", id, c.exp, got)
+			}
+		}
+	}
+
+	//the macros attached at execute time are gone afterwards
+	tmpl := Parse(j("page.tmpl")).Blocks(j("money.block"))
+	if err := tmpl.Execute(ioutil.Discard, data, j("euro.block")); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "USD5" {
+		t.Fatalf("Exp %q\nGot %q", "USD5", got)
+	}
+
+	//macros from files matched by the same glob conflict
+	if err := Parse(j("page.tmpl")).Blocks(j("*.block")).Execute(ioutil.Discard, data); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestFilesTemplateMeta(t *testing.T) {
+	dir := createTestDir(t, []templateFile{
+		{"site.tmpl", "---\ntitle: Site\nlayout: site\n---\n<{% /.@meta.title %}|{% /.@meta.layout %}|{% block content %}{% end block %}>"},
+		{"page.tmpl", "+++\ntitle = \"Page\"\nttl = 60\n+++\n{% extends \"site.tmpl\" %}{% block content %}{% with /.@meta %}{% .ttl %}{% end with %}{% end block %}"},
+		{"json.tmpl", "{\"perms\": [\"admin\"]}\n{% range /.@meta.perms as _ p %}{% .p %}{% end range %}"},
+		{"none.tmpl", `{"a": {% . %}}`},
+		{"side.block", "---\ntitle: Ignored\n---\n{% block content %}side{% end block %}"},
+		{"bad.tmpl", "---\ntitle: Site\n"},
+		{"line.tmpl", "---\ntitle: Site\n---\n\n{% if %}"},
+		{"plain.tmpl", "{\"a\": 1}\n{% . %}"},
+		{"rule.tmpl", "---\n{% . %}"},
+	})
+	defer os.RemoveAll(dir)
+
+	j := func(path string) string {
+		return filepath.Join(dir, path)
+	}
+
+	cases := []struct {
+		tmpl *Template
+		exp  string
+	}{
+		{Parse(j("site.tmpl")).FrontMatter(), "<Site|site|>"},
+		{Parse(j("page.tmpl")).FrontMatter(), "<Page|site|60>"},
+		{Parse(j("json.tmpl")).FrontMatter(), "admin"},
+		{Parse(j("none.tmpl")).FrontMatter(), `{"a": 1}`},
+		{Parse(j("site.tmpl")).FrontMatter().Blocks(j("side.block")), "<Site|site|side>"},
+
+		//without the option the files are used as is
+		{Parse(j("plain.tmpl")), "{\"a\": 1}\n1"},
+		{Parse(j("rule.tmpl")), "---\n1"},
+	}
+	for id, c := range cases {
+		var buf bytes.Buffer
+		if err := c.tmpl.Execute(&buf, 1); err != nil {
+			t.Errorf("%d: %v", id, err)
+			continue
+		}
+		if got := buf.String(); got != c.exp {
+			t.Errorf("%d:\nExp %q\nGot %q", id, c.exp, got)
+		}
+	}
+
+	meta, err := Parse(j("page.tmpl")).FrontMatter().Meta()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]interface{}{"title": "Page", "layout": "site", "ttl": int64(60)}
+	if !reflect.DeepEqual(meta, exp) {
+		t.Errorf("Expected %v got %v", exp, meta)
+	}
+
+	if _, err := Parse(j("bad.tmpl")).FrontMatter().Meta(); err == nil {
+		t.Error("Expected an error for unclosed front matter")
+	}
+
+	//errors in the template count the lines of the front matter
+	err = Parse(j("line.tmpl")).FrontMatter().Execute(ioutil.Discard, nil)
+	if err == nil || !strings.Contains(err.Error(), "4:6") {
+		t.Errorf("Expected an error on line 4 got %v", err)
+	}
+}
+
+func TestFilesTemplateCompile(t *testing.T) {
+	defer CompileMode(<-modeChan)
+	CompileMode(Production)
+
+	dir := createTestDir(t, []templateFile{
+		{"base.tmpl", `{% evoke foo %}`},
+		{"foo.block", `{% block foo %}bar{% end block %}`},
+		{"broken.block", `{% block foo %}{% if %}{% end block %}`},
+	})
+	defer os.RemoveAll(dir)
+
+	j := func(path string) string {
+		return filepath.Join(dir, path)
+	}
+
+	tmp := Parse(j("base.tmpl")).Blocks(j("foo.block"))
+	if err := tmp.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	if tmp.dirty {
+		t.Fatal("Expected the template to be compiled")
+	}
+	var buf bytes.Buffer
+	if err := tmp.Execute(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "bar" {
+		t.Fatalf("\nExp %q\nGot %q", "bar", got)
+	}
+
+	if err := Parse(j("base.tmpl")).Blocks(j("broken.block")).Compile(); err == nil {
+		t.Error("Expected an error for a broken block")
+	}
+	if err := Parse(j("missing.tmpl")).Compile(); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+
+	if MustParse(j("base.tmpl"), j("foo.block")).dirty {
+		t.Error("Expected MustParse to compile the template")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected MustParse to panic")
+		}
+	}()
+	MustParse(j("base.tmpl"), j("broken.block"))
+}
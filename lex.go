@@ -3,6 +3,7 @@ package tmpl
 import (
 	"bytes"
 	"fmt"
+	"regexp"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -13,30 +14,51 @@ type tokenType int
 //TODO: unicode support
 const identifierLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_0123456789"
 
+//operators are the characters that are lexed as a tokenOperator.
+const operators = "+-*/%~"
+
 const (
-	tokenOpen     tokenType = iota // {%
-	tokenClose                     // %}
-	tokenCall                      // call
-	tokenPush                      // .
-	tokenPop                       // $
-	tokenRoot                      // /
-	tokenValue                     // "foo"
-	tokenNumeric                   // -123.5
-	tokenIdent                     // foo (push/pop idents)
-	tokenAs                        // as
-	tokenBlock                     // block
-	tokenEvoke                     // evoke
-	tokenIf                        // if
-	tokenElse                      // else
-	tokenWith                      // with
-	tokenRange                     // range
-	tokenEnd                       // end
-	tokenComment                   // comment
-	tokenLiteral                   // stuff between open/close
-	tokenEOF                       // sent when no data is left
-	tokenStartSel                  // sent at the start of a selector like .foo$bar
-	tokenEndSel                    // sent at the end of a select like .foo$bar
-	tokenError                     // error type
+	tokenOpen      tokenType = iota // {%
+	tokenClose                      // %}
+	tokenCall                       // call
+	tokenPush                       // .
+	tokenPop                        // $
+	tokenRoot                       // /
+	tokenValue                      // "foo"
+	tokenNumeric                    // -123.5
+	tokenIdent                      // foo (push/pop idents)
+	tokenAs                         // as
+	tokenBlock                      // block
+	tokenEvoke                      // evoke
+	tokenIf                         // if
+	tokenElse                       // else
+	tokenWith                       // with
+	tokenRange                      // range
+	tokenEnd                        // end
+	tokenComment                    // comment
+	tokenLiteral                    // stuff between open/close
+	tokenEOF                        // sent when no data is left
+	tokenStartSel                   // sent at the start of a selector like .foo$bar
+	tokenEndSel                     // sent at the end of a select like .foo$bar
+	tokenExtends                    // extends
+	tokenSuper                      // super
+	tokenStackPush                  // push
+	tokenStack                      // stack
+	tokenAssign                     // =
+	tokenSlot                       // slot
+	tokenFill                       // fill
+	tokenInclude                    // include
+	tokenEvokeOpt                   // evoke?
+	tokenLParen                     // (
+	tokenRParen                     // )
+	tokenMacro                      // macro
+	tokenCapture                    // capture
+	tokenSwitch                     // switch
+	tokenCase                       // case
+	tokenDefault                    // default
+	tokenRaw                        // raw
+	tokenOperator                   // + - * / % ~
+	tokenError                      // error type
 
 	//special sentinal value used in the parser
 	tokenNoneType tokenType = -1
@@ -45,12 +67,21 @@ const (
 var (
 	commentOpen  = []byte(`{#`)
 	commentClose = []byte(`#}`)
+
+	//a backslash before an open delimiter makes it literal text
+	escapeChar = byte('\\')
+
+	//the end of a raw section, which is the only thing that ends it
+	rawEnd = regexp.MustCompile(`\{%\s*end\s+raw\s*%\}`)
 )
 
 var tokenNames = []string{
 	"open", "close", "call", "push", "pop", "root", "value", "numeric", "ident",
 	"as", "block", "evoke", "if", "else", "with", "range", "end", "comment",
-	"literal", "eof", "startSel", "endSel", "error",
+	"literal", "eof", "startSel", "endSel", "extends", "super", "stackPush", "stack", "assign", "slot",
+	"fill", "include", "evokeOpt", "lparen", "rparen", "macro",
+	"capture", "switch", "case", "default", "raw",
+	"operator", "error",
 }
 
 func (t tokenType) String() string {
@@ -91,8 +122,27 @@ var (
 	asDelim    = delim{[]byte(`as`), tokenAs}
 	endDelim   = delim{[]byte(`end`), tokenEnd}
 
+	extendsDelim = delim{[]byte(`extends`), tokenExtends}
+	superDelim   = delim{[]byte(`super`), tokenSuper}
+	stackPDelim  = delim{[]byte(`push`), tokenStackPush}
+	stackDelim   = delim{[]byte(`stack`), tokenStack}
+	slotDelim    = delim{[]byte(`slot`), tokenSlot}
+	fillDelim    = delim{[]byte(`fill`), tokenFill}
+	includeDelim = delim{[]byte(`include`), tokenInclude}
+	evokeODelim  = delim{[]byte(`evoke?`), tokenEvokeOpt}
+	macroDelim   = delim{[]byte(`macro`), tokenMacro}
+	captureDelim = delim{[]byte(`capture`), tokenCapture}
+	switchDelim  = delim{[]byte(`switch`), tokenSwitch}
+	caseDelim    = delim{[]byte(`case`), tokenCase}
+	defaultDelim = delim{[]byte(`default`), tokenDefault}
+	rawDelim     = delim{[]byte(`raw`), tokenRaw}
+
 	insideDelims = []delim{callDelim, blockDelim, ifDelim, elseDelim, withDelim, rangeDelim, endDelim, asDelim, evokeDelim}
 	selDelims    = []delim{pushDelim, popDelim, rootDelim}
+
+	//statementDelims are only keywords at the start of a statement or after
+	//an end, so they can still name functions, blocks and variables
+	statementDelims = []delim{evokeODelim, extendsDelim, superDelim, stackPDelim, stackDelim, slotDelim, fillDelim, includeDelim, macroDelim, captureDelim, switchDelim, caseDelim, defaultDelim, rawDelim}
 )
 
 type token struct {
@@ -115,14 +165,24 @@ type lexer struct {
 	tail   int
 	width  int
 	pipe   chan token
+	last   tokenType //the type of the last token emitted
+	raw    bool      //the action being lexed starts a raw section
+	open   token     //the open delim of the action being lexed
 }
 
 type lexerState func(l *lexer) lexerState
 
 func lex(data []byte) chan token {
+	return lexAt(data, 0)
+}
+
+//lexAt lexes data that starts on the given line of a file, such as the part
+//of a file after its front matter.
+func lexAt(data []byte, line int) chan token {
 	l := &lexer{
-		data: data,
-		pipe: make(chan token),
+		data:  data,
+		pipe:  make(chan token),
+		lines: line,
 	}
 	go l.run()
 	return l.pipe
@@ -141,8 +201,15 @@ func (l *lexer) slice() []byte {
 	return l.data[l.tail:l.pos]
 }
 
-//advance moves the tail up to the pos igoring the current token
+//advance moves the tail up to the pos igoring the current token, keeping
+//track of any newlines in it
 func (l *lexer) advance() {
+	dat := l.slice()
+	newlines := bytes.Count(dat, []byte{'\n'})
+	l.lines += newlines
+	if newlines > 0 {
+		l.lastnl = l.tail + bytes.LastIndex(dat, []byte{'\n'}) + 1
+	}
 	l.tail = l.pos
 }
 
@@ -165,18 +232,16 @@ func (l *lexer) backup() {
 
 //emit sends out the current token with the given type
 func (l *lexer) emit(typ tokenType) {
-	//figure out how many more newlines to add
-	dat := l.slice()
-	l.pipe <- token{
+	tok := token{
 		typ:  typ,
-		dat:  dat,
+		dat:  l.slice(),
 		pos:  l.tail - l.lastnl,
 		line: l.lines,
 	}
-	newlines := bytes.Count(dat, []byte{'\n'})
-	l.lines += newlines
-	if newlines > 0 {
-		l.lastnl = l.tail + bytes.LastIndex(dat, []byte{'\n'}) + 1
+	l.pipe <- tok
+	l.last = typ
+	if typ == tokenOpen {
+		l.open = tok
 	}
 	l.advance()
 }
@@ -221,8 +286,26 @@ func (l *lexer) errorf(format string, args ...interface{}) lexerState {
 	return nil
 }
 
+//unclosed reports an action that runs into the end of the data, giving the
+//position it was opened at.
+func (l *lexer) unclosed() lexerState {
+	return l.errorf("unclosed action opened at %d:%d", l.open.line, l.open.pos)
+}
+
 func lexText(l *lexer) lexerState {
 	for {
+		//escaped open tags and comments are left as text without the escape
+		if rest := l.data[l.pos:]; len(rest) > 0 && rest[0] == escapeChar &&
+			(bytes.HasPrefix(rest[1:], openDelim.value) || bytes.HasPrefix(rest[1:], commentOpen)) {
+			if l.pos > l.tail {
+				l.emit(tokenLiteral)
+			}
+			l.pos++
+			l.advance()
+			l.pos += len(openDelim.value)
+			continue
+		}
+
 		//open tags
 		if bytes.HasPrefix(l.data[l.pos:], openDelim.value) {
 			//check if we should emit
@@ -266,9 +349,26 @@ func lexOpenDelim(l *lexer) lexerState {
 func lexCloseDelim(l *lexer) lexerState {
 	l.pos += len(closeDelim.value)
 	l.emit(closeDelim.typ)
+	if l.raw {
+		l.raw = false
+		return lexRaw
+	}
 	return lexText
 }
 
+//lexRaw passes everything up to the end of a raw section through as text.
+func lexRaw(l *lexer) lexerState {
+	loc := rawEnd.FindIndex(l.data[l.pos:])
+	if loc == nil {
+		return l.errorf("unclosed raw section")
+	}
+	l.pos += loc[0]
+	if l.pos > l.tail {
+		l.emit(tokenLiteral)
+	}
+	return lexOpenDelim
+}
+
 func lexPushDelim(l *lexer) lexerState {
 	l.pos += len(pushDelim.value)
 	l.emit(pushDelim.typ)
@@ -285,27 +385,22 @@ func lexInsideDelims(l *lexer) lexerState {
 	for {
 		rest := l.data[l.pos:]
 		//lex the inside tokens that dont change state
-		for _, delim := range insideDelims {
-			if bytes.HasPrefix(rest, delim.value) {
-				l.pos += len(delim.value)
-
-				//if we have a keyword, check that the next letter
-				//either is a space or a close delim follows it
-				if !unicode.IsSpace(l.peek()) &&
-					!bytes.HasPrefix(l.data[l.pos:], closeDelim.value) {
-					//theres more than just a keyword so back up
-					l.pos -= len(delim.value)
-					continue
-				}
-
-				l.emit(delim.typ)
+		if l.last == tokenOpen || l.last == tokenEnd {
+			if typ, ok := l.keyword(statementDelims); ok {
+				//a raw right after the open starts a raw section
+				l.raw = typ == tokenRaw && l.last == tokenOpen
+				l.emit(typ)
 				return lexInsideDelims
 			}
 		}
+		if typ, ok := l.keyword(insideDelims); ok {
+			l.emit(typ)
+			return lexInsideDelims
+		}
 
 		//check for things that start selectors
 		for _, delim := range selDelims {
-			if bytes.HasPrefix(rest, delim.value) {
+			if bytes.HasPrefix(rest, delim.value) && !l.divides() {
 				l.emit(tokenStartSel)
 				return lexInsideSel
 			}
@@ -317,19 +412,27 @@ func lexInsideDelims(l *lexer) lexerState {
 		}
 
 		switch r := l.next(); {
-		case r == eof || r == '\n' || r == '\r':
-			return l.errorf("unclosed action")
+		case r == eof:
+			return l.unclosed()
 		case unicode.IsSpace(r):
 			l.advance()
-		//remove letter/number literals
-		/*
-			case r == '+' || r == '-' || '0' <= r && r <= '9':
-				l.backup()
-				return lexNumber
-			case r == '"':
-				l.advance()
-				return lexValue
-		*/
+		case '0' <= r && r <= '9':
+			l.backup()
+			return lexNumber
+		case (r == '+' || r == '-') && l.signsNumber():
+			l.backup()
+			return lexNumber
+		case strings.ContainsRune(operators, r):
+			l.emit(tokenOperator)
+		case r == '"':
+			l.backup()
+			return lexValue
+		case r == '=':
+			l.emit(tokenAssign)
+		case r == '(':
+			l.emit(tokenLParen)
+		case r == ')':
+			l.emit(tokenRParen)
 		case unicode.IsLetter(r) || r == '_': //go spec
 			return lexIdentifier
 		default:
@@ -339,6 +442,60 @@ func lexInsideDelims(l *lexer) lexerState {
 	return nil
 }
 
+//keyword moves past the next keyword if it is one of the delims, returning
+//its type.
+func (l *lexer) keyword(delims []delim) (tokenType, bool) {
+	for _, delim := range delims {
+		if !bytes.HasPrefix(l.data[l.pos:], delim.value) {
+			continue
+		}
+		l.pos += len(delim.value)
+
+		//if we have a keyword, check that the next letter
+		//either is a space or a close delim follows it
+		if unicode.IsSpace(l.peek()) || bytes.HasPrefix(l.data[l.pos:], closeDelim.value) {
+			return delim.typ, true
+		}
+		//theres more than just a keyword so back up
+		l.pos -= len(delim.value)
+	}
+	return tokenNoneType, false
+}
+
+//divides returns if the next slash divides, rather than starting a rooted
+//selector like /.foo or /$. A slash right after a value divides, as does one
+//with no selector following it.
+func (l *lexer) divides() bool {
+	rest := l.data[l.pos:]
+	if len(rest) == 0 || rest[0] != '/' {
+		return false
+	}
+	if len(rest) == 1 || (rest[1] != '.' && rest[1] != '$') {
+		return true
+	}
+	return l.followsValue(l.pos)
+}
+
+//signsNumber returns if the sign that was just read starts a signed number.
+//A sign right after a value is an operator, so .a-1 subtracts while
+//call f .a -1 passes a negative number.
+func (l *lexer) signsNumber() bool {
+	if r := l.peek(); r < '0' || r > '9' {
+		return false
+	}
+	return !l.followsValue(l.pos - 1)
+}
+
+//followsValue returns if the data at pos comes right after a value, with no
+//space between them.
+func (l *lexer) followsValue(pos int) bool {
+	switch l.last {
+	case tokenNumeric, tokenValue, tokenEndSel, tokenRParen:
+		return pos > 0 && !unicode.IsSpace(rune(l.data[pos-1]))
+	}
+	return false
+}
+
 func lexComment(l *lexer) lexerState {
 	l.pos += len(commentOpen)
 	for !bytes.HasPrefix(l.data[l.pos:], commentClose) {
@@ -354,6 +511,10 @@ func lexComment(l *lexer) lexerState {
 func lexInsideSel(l *lexer) lexerState {
 	for {
 		for _, delim := range selDelims {
+			//a root only starts a selector, anywhere else it divides
+			if delim.typ == tokenRoot && l.last != tokenStartSel {
+				continue
+			}
 			if bytes.HasPrefix(l.data[l.pos:], delim.value) {
 				l.pos += len(delim.value)
 				l.emit(delim.typ)
@@ -367,13 +528,19 @@ func lexInsideSel(l *lexer) lexerState {
 		}
 
 		switch r := l.next(); {
-		case unicode.IsLetter(r) || r == '_': //go spec
+		case unicode.IsLetter(r) || r == '_' || r == '@': //go spec, and @meta
 			l.acceptRun(identifierLetters)
 			l.emit(tokenIdent)
 			return lexInsideSel
 		case unicode.IsSpace(r):
 			l.emit(tokenEndSel)
 			return lexInsideDelims
+		case r == ')' || strings.ContainsRune(operators, r):
+			l.backup()
+			l.emit(tokenEndSel)
+			return lexInsideDelims
+		case r == eof:
+			return l.unclosed()
 		default:
 			return l.errorf("invalid character: %q", r)
 		}
@@ -381,12 +548,23 @@ func lexInsideSel(l *lexer) lexerState {
 	return nil
 }
 
+//lexValue lexes a double quoted string, including the quotes, so that the
+//parser can unquote it with the usual Go escapes.
 func lexValue(l *lexer) lexerState {
-	l.acceptUntil(`"`)
-	l.emit(tokenValue)
-	l.next() //grab the right quote and chunk it
-	l.advance()
-	return lexInsideDelims
+	l.next() //grab the left quote
+	for {
+		switch l.next() {
+		case '\\':
+			if r := l.next(); r == eof || r == '\n' {
+				return l.errorf("unterminated string")
+			}
+		case eof, '\n':
+			return l.errorf("unterminated string")
+		case '"':
+			l.emit(tokenValue)
+			return lexInsideDelims
+		}
+	}
 }
 
 func lexIdentifier(l *lexer) lexerState {
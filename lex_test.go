@@ -25,6 +25,37 @@ func TestLexExpected(t *testing.T) {
 		{`{%.%}`, []tokenType{tokenOpen, tokenStartSel, tokenPush, tokenEndSel, tokenClose, tokenEOF}},
 		{`{% range .foo as _ rangev %}`, []tokenType{tokenOpen, tokenRange, tokenStartSel, tokenPush, tokenIdent, tokenEndSel, tokenAs, tokenIdent, tokenIdent, tokenClose, tokenEOF}},
 		{`{% block block1 %}`, []tokenType{tokenOpen, tokenBlock, tokenIdent, tokenClose, tokenEOF}},
+		{`{% "foo" %}`, []tokenType{tokenOpen, tokenValue, tokenClose, tokenEOF}},
+		{`{% extends "foo" %}`, []tokenType{tokenOpen, tokenExtends, tokenValue, tokenClose, tokenEOF}},
+		{`{% push js once %}`, []tokenType{tokenOpen, tokenStackPush, tokenIdent, tokenIdent, tokenClose, tokenEOF}},
+		{`{% stack js %}`, []tokenType{tokenOpen, tokenStack, tokenIdent, tokenClose, tokenEOF}},
+		{`{% slot footer %}`, []tokenType{tokenOpen, tokenSlot, tokenIdent, tokenClose, tokenEOF}},
+		{`{% fill footer %}`, []tokenType{tokenOpen, tokenFill, tokenIdent, tokenClose, tokenEOF}},
+		{`{% switch . %}{% case 1 %}{% default %}`, []tokenType{tokenOpen, tokenSwitch, tokenStartSel, tokenPush, tokenEndSel, tokenClose,
+			tokenOpen, tokenCase, tokenNumeric, tokenClose, tokenOpen, tokenDefault, tokenClose, tokenEOF}},
+		{`{% raw %}{% if {# %}{% end raw %}`, []tokenType{tokenOpen, tokenRaw, tokenClose, tokenLiteral,
+			tokenOpen, tokenEnd, tokenRaw, tokenClose, tokenEOF}},
+		{`{% raw %}{% end raw %}`, []tokenType{tokenOpen, tokenRaw, tokenClose, tokenOpen, tokenEnd, tokenRaw, tokenClose, tokenEOF}},
+		{`a\{% b`, []tokenType{tokenLiteral, tokenLiteral, tokenEOF}},
+		{`{% capture x %}`, []tokenType{tokenOpen, tokenCapture, tokenIdent, tokenClose, tokenEOF}},
+		{`{% macro m a b %}`, []tokenType{tokenOpen, tokenMacro, tokenIdent, tokenIdent, tokenIdent, tokenClose, tokenEOF}},
+		{`{% evoke? (.a) %}`, []tokenType{tokenOpen, tokenEvokeOpt, tokenLParen, tokenStartSel, tokenPush, tokenIdent,
+			tokenEndSel, tokenRParen, tokenClose, tokenEOF}},
+		{`{% evoke b x=.y z="w" %}`, []tokenType{tokenOpen, tokenEvoke, tokenIdent, tokenIdent, tokenAssign,
+			tokenStartSel, tokenPush, tokenIdent, tokenEndSel, tokenIdent, tokenAssign, tokenValue, tokenClose, tokenEOF}},
+		{`{%"foo \" %}"%}`, []tokenType{tokenOpen, tokenValue, tokenClose, tokenEOF}},
+		{"{% call f\n\t.a\r\n\t.b\n%}", []tokenType{tokenOpen, tokenCall, tokenIdent, tokenStartSel, tokenPush, tokenIdent, tokenEndSel,
+			tokenStartSel, tokenPush, tokenIdent, tokenEndSel, tokenClose, tokenEOF}},
+		{"{%\nif\n.a\n%}", []tokenType{tokenOpen, tokenIf, tokenStartSel, tokenPush, tokenIdent, tokenEndSel, tokenClose, tokenEOF}},
+		{`{% call f 1 -2 +3.5 4e2 %}`, []tokenType{tokenOpen, tokenCall, tokenIdent, tokenNumeric, tokenNumeric, tokenNumeric, tokenNumeric, tokenClose, tokenEOF}},
+		{`{% .a-1 %}`, []tokenType{tokenOpen, tokenStartSel, tokenPush, tokenIdent, tokenEndSel, tokenOperator, tokenNumeric, tokenClose, tokenEOF}},
+		{`{% 2*.a/$.b %}`, []tokenType{tokenOpen, tokenNumeric, tokenOperator, tokenStartSel, tokenPush, tokenIdent, tokenEndSel,
+			tokenOperator, tokenStartSel, tokenPop, tokenPush, tokenIdent, tokenEndSel, tokenClose, tokenEOF}},
+		{`{% 1 / /.a %}`, []tokenType{tokenOpen, tokenNumeric, tokenOperator, tokenStartSel, tokenRoot, tokenPush, tokenIdent, tokenEndSel, tokenClose, tokenEOF}},
+		{`{% call push default %}`, []tokenType{tokenOpen, tokenCall, tokenIdent, tokenIdent, tokenClose, tokenEOF}},
+		{`{% block case %}{% end push %}`, []tokenType{tokenOpen, tokenBlock, tokenIdent, tokenClose, tokenOpen, tokenEnd, tokenStackPush, tokenClose, tokenEOF}},
+		{`{% (1 - 2) % 3 ~ "px"%}`, []tokenType{tokenOpen, tokenLParen, tokenNumeric, tokenOperator, tokenNumeric, tokenRParen,
+			tokenOperator, tokenNumeric, tokenOperator, tokenValue, tokenClose, tokenEOF}},
 	}
 
 	for id, c := range cases {
@@ -52,6 +83,8 @@ func TestLexExpectedFailures(t *testing.T) {
 		code string
 	}{
 		{`{#`},
+		{`{% raw %}`},
+		{`{% raw %}{% end %}`},
 		{`{#}`},
 		{`{%`},
 		{`{%}`},
@@ -59,21 +92,19 @@ func TestLexExpectedFailures(t *testing.T) {
 		{`{% {% %} . %}`},
 		{`{% {# . %}`},
 		{`{% {# #} . %}`},
-		{`{% ~ %}`},
 		{`{% ! %}`},
 		{`{% @ %}`},
 		{`{% # %}`},
-		{`{% % %}`},
 		{`{% ^ %}`},
 		{`{% & %}`},
-		{`{% * %}`},
-		{`{% ( %}`},
-		{`{% ) %}`},
-		{`{% - %}`},
-		{`{% + %}`},
-		{`{% = %}`},
 		{`{% if !.foo %}`},
 		{`{% if ! .foo %}`},
+		{`{% "foo %}`},
+		{`{% "foo`},
+		{"{% \"foo\n\" %}"},
+		{`{% "foo\`},
+		{"{% call f\n"},
+		{"{% .foo"},
 	}
 
 caseBlock:
@@ -89,6 +120,64 @@ caseBlock:
 	}
 }
 
+func TestLexPositions(t *testing.T) {
+	code := "a\n{% call f\n\t.a\n  .b %}{% .c %}\n{%\n  .d %}"
+	expect := []struct {
+		typ       tokenType
+		line, pos int
+	}{
+		{tokenLiteral, 0, 0},
+		{tokenOpen, 1, 0},
+		{tokenCall, 1, 3},
+		{tokenIdent, 1, 8},
+		{tokenStartSel, 2, 1},
+		{tokenPush, 2, 1},
+		{tokenIdent, 2, 2},
+		{tokenEndSel, 2, 3},
+		{tokenStartSel, 3, 2},
+		{tokenPush, 3, 2},
+		{tokenIdent, 3, 3},
+		{tokenEndSel, 3, 4},
+		{tokenClose, 3, 5},
+		{tokenOpen, 3, 7},
+		{tokenStartSel, 3, 10},
+		{tokenPush, 3, 10},
+		{tokenIdent, 3, 11},
+		{tokenEndSel, 3, 12},
+		{tokenClose, 3, 13},
+		{tokenLiteral, 3, 15},
+		{tokenOpen, 4, 0},
+		{tokenStartSel, 5, 2},
+		{tokenPush, 5, 2},
+		{tokenIdent, 5, 3},
+		{tokenEndSel, 5, 4},
+		{tokenClose, 5, 5},
+		{tokenEOF, 5, 7},
+	}
+	var toks []token
+	for tok := range lex([]byte(code)) {
+		toks = append(toks, tok)
+	}
+	if len(toks) != len(expect) {
+		t.Fatalf("Expected %d tokens got %v", len(expect), toks)
+	}
+	for i, ex := range expect {
+		if tok := toks[i]; tok.typ != ex.typ || tok.line != ex.line || tok.pos != ex.pos {
+			t.Errorf("%d: Expected %s at %d:%d got %v", i, tokenNames[ex.typ], ex.line, ex.pos, tok)
+		}
+	}
+}
+
+func TestLexUnclosedAction(t *testing.T) {
+	var last token
+	for tok := range lex([]byte("foo\n  {% call f\n.a\n")) {
+		last = tok
+	}
+	if last.typ != tokenError || string(last.dat) != "unclosed action opened at 1:2" {
+		t.Errorf("Expected an unclosed action error got %v", last)
+	}
+}
+
 func TestLexAllTokensNamed(t *testing.T) {
 	if len(tokenNames) != int(tokenError)+1 {
 		t.Fatalf("%d tokens %d names", tokenError+1, len(tokenNames))
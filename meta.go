@@ -0,0 +1,404 @@
+package tmpl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//metaKey is the first key of a rooted selector that reaches the front matter
+//of the template rather than the data, as in /.@meta.title
+const metaKey = "@meta"
+
+//frontMatter splits the front matter off the top of a template file,
+//returning the values in it, the rest of the file and the number of lines the
+//front matter took up. Files without any front matter are returned as is.
+//
+//YAML front matter is fenced by lines of ---, TOML by lines of +++, and JSON
+//is a single object starting on the first line and ending a line.
+func frontMatter(data []byte) (meta map[string]interface{}, rest []byte, lines int, err error) {
+	switch {
+	case isFence(data, "---"):
+		return fencedMatter(data, "---", parseYAML)
+	case isFence(data, "+++"):
+		return fencedMatter(data, "+++", parseTOML)
+	case len(data) > 1 && data[0] == '{' && (data[1] == '"' || data[1] == '}' || isSpace(data[1])):
+		return jsonMatter(data)
+	}
+	return nil, data, 0, nil
+}
+
+//isFence returns if the line at the start of data is the fence.
+func isFence(data []byte, fence string) bool {
+	line := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		line = data[:i]
+	}
+	return string(bytes.TrimRight(line, " \t\r")) == fence
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\n'
+}
+
+//fencedMatter parses the front matter between the fence lines with parse.
+func fencedMatter(data []byte, fence string, parse func([]string) (map[string]interface{}, error)) (meta map[string]interface{}, rest []byte, lines int, err error) {
+	var body []string
+	for pos := 0; pos < len(data); {
+		end := bytes.IndexByte(data[pos:], '\n')
+		if end < 0 {
+			end = len(data)
+		} else {
+			end += pos + 1
+		}
+		line := strings.TrimRight(string(data[pos:end]), "\r\n")
+		pos = end
+		lines++
+		if lines == 1 {
+			continue
+		}
+		if strings.TrimRight(line, " \t") == fence {
+			meta, err = parse(body)
+			return meta, data[pos:], lines, err
+		}
+		body = append(body, line)
+	}
+	return nil, nil, 0, fmt.Errorf("0:0: unclosed front matter")
+}
+
+//jsonMatter parses a JSON object at the start of data. Templates that output
+//JSON can start the same way, so unless the object parses and ends its line,
+//data is taken to have no front matter.
+func jsonMatter(data []byte) (meta map[string]interface{}, rest []byte, lines int, err error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if dec.Decode(&meta) != nil {
+		return nil, data, 0, nil
+	}
+	pos := int(dec.InputOffset())
+	end := bytes.IndexByte(data[pos:], '\n')
+	if end < 0 {
+		end = len(data) - pos
+	}
+	if len(bytes.TrimSpace(data[pos:pos+end])) > 0 {
+		return nil, data, 0, nil
+	}
+	pos += end
+	if pos < len(data) {
+		pos++
+	}
+	return jsonNumbers(meta).(map[string]interface{}), data[pos:], bytes.Count(data[:pos], []byte{'\n'}), nil
+}
+
+//jsonNumbers replaces the json.Numbers in v with int64s or float64s, to match
+//the numbers in the other formats and in templates.
+func jsonNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, val := range v {
+			v[key] = jsonNumbers(val)
+		}
+	case []interface{}:
+		for i, val := range v {
+			v[i] = jsonNumbers(val)
+		}
+	}
+	return v
+}
+
+// ********
+// * YAML *
+// ********
+
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+//parseYAML parses the subset of YAML used for front matter: mappings of keys
+//to scalars, flow lists like [a, b], and nested mappings and block lists
+//introduced by indentation. Anything else, like block scalars or anchors, is
+//an error rather than being read as a string.
+func parseYAML(lines []string) (map[string]interface{}, error) {
+	var ls []yamlLine
+	for i, line := range lines {
+		text := strings.TrimLeft(line, " ")
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("%d:0: tabs can't indent YAML", i+1)
+		}
+		ls = append(ls, yamlLine{i + 1, len(line) - len(text), strings.TrimRight(text, " \t")})
+	}
+	m, rest, err := yamlMap(ls, 0)
+	if err == nil && len(rest) > 0 {
+		err = fmt.Errorf("%d:%d: unexpected indentation", rest[0].num, rest[0].indent)
+	}
+	return m, err
+}
+
+//yamlMap parses the mapping made of the lines at the indentation, returning
+//the lines after it.
+func yamlMap(ls []yamlLine, indent int) (m map[string]interface{}, rest []yamlLine, err error) {
+	m = map[string]interface{}{}
+	for len(ls) > 0 && ls[0].indent == indent {
+		l := ls[0]
+		colon := yamlKey(l.text)
+		if colon < 0 {
+			return nil, nil, fmt.Errorf("%d:%d: expected a key: value pair", l.num, l.indent)
+		}
+		key := strings.TrimSpace(l.text[:colon])
+		if _, ex := m[key]; ex {
+			return nil, nil, fmt.Errorf("%d:%d: key %q given twice", l.num, l.indent, key)
+		}
+		val := strings.TrimSpace(l.text[colon+1:])
+		ls = ls[1:]
+		if val != "" {
+			if m[key], err = parseScalar(val, true); err != nil {
+				return nil, nil, fmt.Errorf("%d:%d: %v", l.num, l.indent+colon+2, err)
+			}
+			continue
+		}
+
+		//an empty value is either a nested block or nothing at all
+		switch {
+		case len(ls) > 0 && ls[0].indent >= indent && strings.HasPrefix(ls[0].text, "- "):
+			m[key], ls, err = yamlList(ls, ls[0].indent)
+		case len(ls) > 0 && ls[0].indent > indent:
+			m[key], ls, err = yamlMap(ls, ls[0].indent)
+		default:
+			m[key] = nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return m, ls, nil
+}
+
+//yamlKey returns the position of the colon ending the key at the start of
+//text, or -1 if text doesn't start with a key.
+func yamlKey(text string) int {
+	colon := strings.Index(text, ":")
+	if colon <= 0 || (colon+1 < len(text) && text[colon+1] != ' ') {
+		return -1
+	}
+	if text[0] == '"' || text[0] == '\'' || text[0] == '[' {
+		return -1
+	}
+	return colon
+}
+
+//yamlList parses the block list made of the lines at the indentation.
+func yamlList(ls []yamlLine, indent int) (list []interface{}, rest []yamlLine, err error) {
+	for len(ls) > 0 && ls[0].indent == indent && strings.HasPrefix(ls[0].text, "- ") {
+		l := ls[0]
+		text := strings.TrimLeft(l.text[2:], " ")
+		col := l.indent + len(l.text) - len(text)
+
+		//an item starting with a key is a mapping lined up after the dash
+		if yamlKey(text) >= 0 {
+			var m map[string]interface{}
+			item := append([]yamlLine{{l.num, col, text}}, ls[1:]...)
+			if m, ls, err = yamlMap(item, col); err != nil {
+				return nil, nil, err
+			}
+			list = append(list, m)
+			continue
+		}
+
+		v, err := parseScalar(text, true)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%d:%d: %v", l.num, col, err)
+		}
+		list = append(list, v)
+		ls = ls[1:]
+	}
+	return list, ls, nil
+}
+
+// ********
+// * TOML *
+// ********
+
+//parseTOML parses the subset of TOML used for front matter: key = value pairs
+//with strings, numbers, booleans and arrays, grouped under [table] headers.
+func parseTOML(lines []string) (map[string]interface{}, error) {
+	root := map[string]interface{}{}
+	table := root
+	for i, line := range lines {
+		text := strings.TrimSpace(line)
+		switch {
+		case text == "" || text[0] == '#':
+			continue
+
+		case text[0] == '[':
+			if text[len(text)-1] != ']' {
+				return nil, fmt.Errorf("%d:0: unclosed table header", i+1)
+			}
+			table = root
+			for _, name := range strings.Split(text[1:len(text)-1], ".") {
+				name = strings.TrimSpace(name)
+				next, ok := table[name].(map[string]interface{})
+				if !ok {
+					if _, ex := table[name]; ex {
+						return nil, fmt.Errorf("%d:0: %q is not a table", i+1, name)
+					}
+					next = map[string]interface{}{}
+					table[name] = next
+				}
+				table = next
+			}
+
+		default:
+			eq := strings.Index(text, "=")
+			if eq <= 0 {
+				return nil, fmt.Errorf("%d:0: expected a key = value pair", i+1)
+			}
+			key := strings.Trim(strings.TrimSpace(text[:eq]), `"`)
+			if _, ex := table[key]; ex {
+				return nil, fmt.Errorf("%d:0: key %q given twice", i+1, key)
+			}
+			v, err := parseScalar(strings.TrimSpace(text[eq+1:]), false)
+			if err != nil {
+				return nil, fmt.Errorf("%d:%d: %v", i+1, eq+1, err)
+			}
+			table[key] = v
+		}
+	}
+	return root, nil
+}
+
+// ***********
+// * Scalars *
+// ***********
+
+//yamlWords are the bare words that YAML 1.2 reads as something other than a
+//string. Words like yes and off are strings, so a country code like NO stays
+//as written.
+var yamlWords = map[string]interface{}{
+	"~": nil, "null": nil, "Null": nil, "NULL": nil,
+	"true": true, "True": true, "TRUE": true,
+	"false": false, "False": false, "FALSE": false,
+}
+
+//parseScalar parses a single value: a quoted string, a number, a boolean or a
+//list of values in brackets. Bare words are strings if bare is true, as they
+//are in YAML, and an error otherwise.
+func parseScalar(s string, bare bool) (interface{}, error) {
+	s = stripComment(s)
+	if v, ex := yamlWords[s]; ex && bare {
+		return v, nil
+	}
+	switch {
+	case s == "":
+		return nil, fmt.Errorf("missing value")
+	case bare && (s[0] == '|' || s[0] == '>'):
+		return nil, fmt.Errorf("block scalars are not supported")
+	case bare && strings.IndexByte("&*!%@`{", s[0]) >= 0:
+		return nil, fmt.Errorf("unsupported value %s", s)
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	case s[0] == '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", s)
+		}
+		return v, nil
+	case s[0] == '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return nil, fmt.Errorf("invalid string %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	case s[0] == '[':
+		return parseList(s, bare)
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	if bare {
+		return s, nil
+	}
+	return nil, fmt.Errorf("invalid value %s", s)
+}
+
+//parseList parses a list of values like [1, "a", b].
+func parseList(s string, bare bool) (interface{}, error) {
+	if s[len(s)-1] != ']' {
+		return nil, fmt.Errorf("unclosed list %s", s)
+	}
+	list := []interface{}{}
+	for _, item := range splitList(s[1 : len(s)-1]) {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		v, err := parseScalar(item, bare)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+//splitList splits the items of a list on the commas outside of strings and
+//nested lists.
+func splitList(s string) (items []string) {
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, s[start:])
+}
+
+//stripComment removes a trailing # comment that isn't inside a string.
+func stripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return strings.TrimSpace(s[:i])
+		}
+	}
+	return s
+}
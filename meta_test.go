@@ -0,0 +1,98 @@
+package tmpl
+
+import (
+	"reflect"
+	"testing"
+)
+
+type m = map[string]interface{}
+
+func TestMetaFrontMatter(t *testing.T) {
+	cases := []struct {
+		code  string
+		meta  map[string]interface{}
+		rest  string
+		lines int
+	}{
+		{"no front matter", nil, "no front matter", 0},
+		{"---\n---\nrest", m{}, "rest", 2},
+		{"---\r\na: 1\r\n---\r\nrest", m{"a": int64(1)}, "rest", 3},
+		{
+			"---\n# comment\ntitle: Hello, world # greeting\nratio: 1.5\ndraft: false\nquoted: \"a # b\"\nsingle: 'x'\n" +
+				"tags: [a, \"b, c\", 3]\nauthor:\n  name: Bob\n  roles:\n    - admin\n    - dev\nempty:\n---\nrest",
+			m{
+				"title": "Hello, world", "ratio": 1.5, "draft": false, "quoted": "a # b", "single": "x",
+				"tags":   []interface{}{"a", "b, c", int64(3)},
+				"author": m{"name": "Bob", "roles": []interface{}{"admin", "dev"}},
+				"empty":  nil,
+			},
+			"rest", 15,
+		},
+		{
+			"---\na: null\nb: ~\nc: yes\nd: True\ne: Off\nf: \"null\"\ng: [no, ~]\nh: FALSE\n---\nrest",
+			m{"a": nil, "b": nil, "c": "yes", "d": true, "e": "Off", "f": "null", "g": []interface{}{"no", nil}, "h": false},
+			"rest", 10,
+		},
+		{
+			"---\nusers:\n- name: Bob\n  roles:\n    - admin\n-   name: Ann\n- plain\n---\nrest",
+			m{"users": []interface{}{m{"name": "Bob", "roles": []interface{}{"admin"}}, m{"name": "Ann"}, "plain"}},
+			"rest", 8,
+		},
+		{
+			"+++\ntitle = \"Hi\" # comment\nttl = 60\nperms = [\"a\", [1, 2]]\n\n[cache]\non = true\n[cache.keys]\nx = 1.0\n+++\nrest",
+			m{
+				"title": "Hi", "ttl": int64(60), "perms": []interface{}{"a", []interface{}{int64(1), int64(2)}},
+				"cache": m{"on": true, "keys": m{"x": 1.0}},
+			},
+			"rest", 10,
+		},
+		{"{\"a\": {\"b\": [1, 2.5]}}\nrest", m{"a": m{"b": []interface{}{int64(1), 2.5}}}, "rest", 1},
+		{"{\n  \"a\": 1\n}  \nrest", m{"a": int64(1)}, "rest", 3},
+		{"{}", m{}, "", 0},
+		{"{\"a\": 1} tail", nil, "{\"a\": 1} tail", 0},
+		{"{% . %}", nil, "{% . %}", 0},
+	}
+	for id, c := range cases {
+		meta, rest, lines, err := frontMatter([]byte(c.code))
+		if err != nil {
+			t.Errorf("%d: %v", id, err)
+			continue
+		}
+		if !reflect.DeepEqual(meta, c.meta) {
+			t.Errorf("%d: Expected %#v got %#v", id, c.meta, meta)
+		}
+		if string(rest) != c.rest || lines != c.lines {
+			t.Errorf("%d: Expected %q after %d lines got %q after %d", id, c.rest, c.lines, rest, lines)
+		}
+	}
+}
+
+func TestMetaFrontMatterFailures(t *testing.T) {
+	cases := []string{
+		"---\na: 1\n",
+		"---\na\n---\n",
+		"---\na:b\n---\n",
+		"---\na: 1\na: 2\n---\n",
+		"---\na: 1\n  b: 2\n---\n",
+		"---\na:\n\t- b\n---\n",
+		"---\na: \"b\n---\n",
+		"---\na: [1, 2\n---\n",
+		"---\na: |\n  text\n---\n",
+		"---\na: >-\n  text\n---\n",
+		"---\na: &anchor b\n---\n",
+		"---\na: {b: 1}\n---\n",
+		"---\na:\n  - b: 1\n    c\n---\n",
+		"+++\na = yes\n+++\n",
+		"+++\na = b\n+++\n",
+		"+++\na\n+++\n",
+		"+++\n[a\n+++\n",
+		"+++\na = 1\n[a]\n+++\n",
+		"+++\na = 1\na = 2\n+++\n",
+		"+++\na =\n+++\n",
+	}
+	for id, c := range cases {
+		if _, _, _, err := frontMatter([]byte(c)); err == nil {
+			t.Errorf("%d: Expected an error for %q", id, c)
+		}
+	}
+}
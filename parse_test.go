@@ -71,7 +71,6 @@ func TestParseExpectedFailures(t *testing.T) {
 
 		//cant evoke or with on things other than selectors
 		{`{% evoke foo ctx %}`},
-		{`{% evoke call foo %}`},
 		{`{% evoke foo call foo %}`},
 		{`{% with call foo %}`},
 		{`{% with call foo %}{% end with %}`},
@@ -85,6 +84,92 @@ func TestParseExpectedFailures(t *testing.T) {
 		{`{% with .foo flabdab %}{% end with %}`},
 		{`{% if .foo %}{% else flabdab %}{% end if %}`},
 		{`{% elseif %}`},
+		{`{% "\q" %}`},
+		{`{% extends %}`},
+		{`{% extends .foo %}`},
+		{`{% extends "" %}`},
+		{`{% extends "a" "b" %}`},
+		{`{% extends "a" %}{% extends "b" %}`},
+		{`{% block foo %}{% extends "a" %}{% end block %}`},
+		{`{% if . %}{% extends "a" %}{% end if %}`},
+		{`{% super %}`},
+		{`{% if . %}{% super %}{% end if %}`},
+		{`{% block foo %}{% super . %}{% end block %}`},
+		{`{% block foo after %}{% end block %}`},
+		{`{% block foo append prepend %}{% end block %}`},
+		{`{% push %}{% end push %}`},
+		{`{% push js %}`},
+		{`{% push js twice %}{% end push %}`},
+		{`{% stack %}`},
+		{`{% stack js css %}`},
+		{`{% = %}`},
+		{`{% end evoke %}`},
+		{`{% evoke foo %}{% end evoke %}{% end evoke %}`},
+		{`{% evoke foo %}{% end if %}`},
+		{`{% evoke foo %}{% else %}`},
+		{`{% if . %}{% evoke foo %}{% end if %}{% end evoke %}`},
+		{`{% fill foo %}{% end fill %}`},
+		{`{% evoke foo %}{% if . %}{% fill foo %}{% end fill %}{% end if %}{% end evoke %}`},
+		{`{% evoke foo %}{% fill a %}{% end fill %}{% fill a %}{% end fill %}{% end evoke %}`},
+		{`{% slot "foo" %}`},
+		{`{% fill %}{% end fill %}`},
+		{`{% include %}`},
+		{`{% include foo %}`},
+		{`{% include "" %}`},
+		{`{% include "foo" ignore %}`},
+		{`{% include "foo" ignore errors %}`},
+		{`{% ~ %}`},
+		{`{% % %}`},
+		{`{% * %}`},
+		{`{% - %}`},
+		{`{% + %}`},
+		{`{% 1 + %}`},
+		{`{% * 1 %}`},
+		{`{% 1 + * 2 %}`},
+		{`{% 1 / 0 %}`},
+		{`{% 1 % 0 %}`},
+		{`{% 1.5 % 2 %}`},
+		{`{% "a" - "b" %}`},
+		{`{% "a" + 1 %}`},
+		{`{% -"a" %}`},
+		{`{% ( %}`},
+		{`{% ) %}`},
+		{`{% (.foo %}`},
+		{`{% evoke ( %}`},
+		{`{% evoke (.foo %}`},
+		{`{% evoke? %}`},
+		{`{% evoke "foo" %}`},
+		{`{% macro %}{% end macro %}`},
+		{`{% macro m %}`},
+		{`{% macro m "a" %}{% end macro %}`},
+		{`{% macro m %}{% end macro %}{% macro m %}{% end macro %}`},
+		{`{% macro m %}{% macro n %}{% end macro %}{% end macro %}`},
+		{`{% macro m %}{% block b %}{% end block %}{% end macro %}`},
+		{`{% block b %}{% macro m %}{% end macro %}{% end block %}`},
+		{`{% block b %}{% if . %}{% macro m %}{% end macro %}{% end if %}{% end block %}`},
+		{`{% macro m %}{% super %}{% end macro %}`},
+		{`{% capture %}{% end capture %}`},
+		{`{% capture x %}`},
+		{`{% capture x y %}{% end capture %}`},
+		{`{% switch %}{% end switch %}`},
+		{`{% switch . %}`},
+		{`{% switch . %}foo{% case 1 %}{% end switch %}`},
+		{`{% switch . %}{% case %}{% end switch %}`},
+		{`{% switch . %}{% default %}{% case 1 %}{% end switch %}`},
+		{`{% switch . %}{% default %}{% default %}{% end switch %}`},
+		{`{% switch . %}{% else %}{% end switch %}`},
+		{`{% case 1 %}`},
+		{`{% default %}`},
+		{`{% if . %}{% case 1 %}{% end if %}`},
+		{`{% switch . %}{% case 1 %}{% end if %}`},
+		{`{% raw x %}{% end raw %}`},
+		{`{% end raw %}`},
+		{`{% evoke foo x %}`},
+		{`{% evoke foo x= %}`},
+		{`{% evoke foo x=.y x=.z %}`},
+		{`{% evoke foo . .x %}`},
+		{`{% block foo as %}{% end block %}`},
+		{`{% block foo as "x" %}{% end block %}`},
 	})
 }
 
@@ -101,6 +186,12 @@ func TestParseExpectedPasses(t *testing.T) {
 		{`{% block foo %}{% with . %}{% end with %}{% end block %}`},
 		{`{% with .foo %}{% end with %}`},
 		{`{% with /. %}{% end with %}`},
+		{`{% .a + 1 %}`},
+		{`{% -.a %}`},
+		{`{% (1 + .a) * 2 %}`},
+		{`{% .a ~ "px" %}`},
+		{`{% if .a % 2 %}{% end if %}`},
+		{`{% call f (.a - 1) %}`},
 		{`{% evoke foo %}`},
 		{`{% evoke foo . %}`},
 		{`{% evoke foo /. %}`},
@@ -119,6 +210,50 @@ func TestParseExpectedPasses(t *testing.T) {
 		{`{% $.foo %}`},
 		{`{% call foo %}`},
 		{`{% call foo /. %}`},
+		{`{% call foo "bar" 1 2.5 %}`},
+		{`{% "foo" %}`},
+		{`{% 1 %}`},
+		{`{% if call eq . "foo" %}{% end if %}`},
+		{`{% extends "base.tmpl" %}{% block foo %}{% end block %}`},
+		{`{%extends "base.tmpl"%}`},
+		{`{% block foo %}{% super %}{% end block %}`},
+		{`{% block foo %}{% if . %}{%super%}{% end if %}{% end block %}`},
+		{`{% block foo append %}{% end block %}`},
+		{`{% block foo prepend %}{% end block %}`},
+		{`{% push js %}{% end push %}`},
+		{`{% push js once %}{% end push %}`},
+		{`{% stack js %}`},
+		{`{% evoke foo x=.y %}`},
+		{`{% evoke foo . x=.y z="w" n=1 f=call foo %}`},
+		{`{% block foo as x y %}{% end block %}`},
+		{`{% block foo append as x %}{% end block %}`},
+		{`{% evoke foo %}body{% end evoke %}`},
+		{`{% evoke foo . x=.y %}{% fill a %}a{% end fill %}body{% end evoke %}`},
+		{`{% evoke foo %}{% evoke bar %}{% end evoke %}{% end evoke %}`},
+		{`{% evoke card %}{% evoke icon %}text{% end evoke %}`},
+		{`{% if . %}{% evoke foo %}{% else %}{% evoke bar %}x{% end evoke %}{% end if %}`},
+		{`{% switch . %}{% case 1 %}{% evoke a %}{% case 2 %}{% evoke b %}{% end evoke %}{% end switch %}`},
+		{`{% evoke foo body=.x %}`},
+		{`{% block foo %}{% slot %}{% slot bar %}{% end block %}`},
+		{`{% include "foo.tmpl" %}`},
+		{`{% include "foo.tmpl" .bar ignore missing %}`},
+		{`{% evoke call foo %}`},
+		{`{% evoke call foo .bar %}`},
+		{`{% evoke (.kind) .bar %}`},
+		{`{% evoke (call foo .bar) .bar %}`},
+		{`{% evoke? foo %}`},
+		{`{% evoke? (.kind) . x=.y %}body{% end evoke %}`},
+		{`{% (.foo) %}`},
+		{`{% call foo (call bar .baz) 1 %}`},
+		{`{% macro m %}{% end macro %}`},
+		{`{% macro price amount currency %}{% .amount %}{% end macro %}`},
+		{`{% capture x %}{% .y %}{% end capture %}{% .x %}`},
+		{`{% if . %}{% capture x %}{% end capture %}{% else %}{% end if %}`},
+		{`{% switch . %}{% end switch %}`},
+		{`{% switch . %} {% case 1 "a" .b call c %}{% case 2 %}{% default %}{% end switch %}`},
+		{`{% switch . %}{% case 1 %}{% switch . %}{% case 2 %}{% end switch %}{% end switch %}`},
+		{`{% raw %}{% if %}{% end raw %}`},
+		{`{% if . %}{% raw %}{% end if %}{% end raw %}{% end if %}`},
 	})
 }
 
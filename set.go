@@ -0,0 +1,218 @@
+package tmpl
+
+import (
+	gocontext "context"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//Set is a collection of templates loaded from a directory tree. Every file
+//ending in .tmpl is a page, known by its path from the root of the tree with
+//forward slashes and without the extension, so that "users/show.tmpl" is the
+//page "users/show". Every file ending in .block is a partial, and the blocks
+//and macros in all of them are attached to every page, under the definitions
+//of the page and the templates it extends, so that those can replace them or
+//build on them with super. The extensions can be changed with Extensions.
+//
+//Functions and built in functions are configured once on the set and shared
+//by all of its pages. The tree is walked the first time a page is needed, and
+//again for every page needed in Development mode, so that new files are
+//always found.
+type Set struct {
+	src  source
+	root string
+
+	pageExt, blockExt string
+	funcs             []funcDecl
+	builtins          map[string]bool
+	front             bool
+
+	//the pages by name, loaded when dirty
+	pages map[string]*Template
+	dirty bool
+	lk    sync.RWMutex
+}
+
+//NewSet creates a Set of the templates in the directory dir.
+func NewSet(dir string) *Set {
+	return newSet(osSource{}, dir)
+}
+
+//NewSetFS creates a Set of the templates in the file system fsys. Every file
+//the templates reference, like the files they extend or include, is read from
+//fsys, and names starting with a slash are rooted at the root of fsys.
+func NewSetFS(fsys fs.FS) *Set {
+	return newSet(newFSSource(fsys), ".")
+}
+
+func newSet(src source, root string) *Set {
+	return &Set{
+		src:      src,
+		root:     root,
+		pageExt:  ".tmpl",
+		blockExt: ".block",
+		dirty:    true,
+	}
+}
+
+//Extensions sets the extensions of the files that are pages and the files
+//that are partials, including the dot.
+func (s *Set) Extensions(page, block string) *Set {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.pageExt, s.blockExt = page, block
+	s.dirty = true
+	return s
+}
+
+//Call attaches a function to every page of the set under the specified name,
+//following the same rules as Template.Call.
+func (s *Set) Call(name string, fnc interface{}) *Set {
+	val := funcValue(name, fnc)
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.funcs = append(s.funcs, funcDecl{name, val})
+	s.dirty = true
+	return s
+}
+
+//Builtins restricts the built in functions available to every page of the
+//set to the given names, following the same rules as Template.Builtins.
+func (s *Set) Builtins(names ...string) *Set {
+	set := builtinSet(names)
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.builtins = set
+	s.dirty = true
+	return s
+}
+
+//FrontMatter makes every page of the set read the front matter from the top
+//of its files, like Template.FrontMatter.
+func (s *Set) FrontMatter() *Set {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.front = true
+	s.dirty = true
+	return s
+}
+
+//loaded returns the pages of the set, walking the tree for them if the set
+//has changed or mode is Development.
+func (s *Set) loaded(mode Mode) (pages map[string]*Template, err error) {
+	s.lk.RLock()
+	if !s.dirty && mode == Production {
+		pages = s.pages
+	}
+	s.lk.RUnlock()
+	if pages != nil {
+		return
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if pages, err = s.load(); err != nil {
+		return
+	}
+	s.pages, s.dirty = pages, false
+	return
+}
+
+//load walks the tree, creating a template for every page with every partial
+//attached.
+func (s *Set) load() (pages map[string]*Template, err error) {
+	files := map[string]string{}
+	var partials []string
+	err = s.src.walk(s.root, func(file, name string) error {
+		switch {
+		case strings.HasSuffix(name, s.blockExt):
+			partials = append(partials, file)
+		case strings.HasSuffix(name, s.pageExt):
+			files[strings.TrimSuffix(name, s.pageExt)] = file
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	pages = map[string]*Template{}
+	for name, file := range files {
+		t := newTemplate(file)
+		t.src = s.src
+		t.partials = partials
+		t.funcs = s.funcs[:len(s.funcs):len(s.funcs)]
+		t.builtins = s.builtins
+		t.front = s.front
+		pages[name] = t
+	}
+	return
+}
+
+//Compile walks the tree and compiles every page of the set like
+//Template.Compile, returning the first error found.
+func (s *Set) Compile() (err error) {
+	pages, err := s.loaded(<-modeChan)
+	if err != nil {
+		return
+	}
+	for _, name := range sortedPages(pages) {
+		if err = pages[name].Compile(); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return
+}
+
+//Names returns the names of the pages in the set in order, or nil if the tree
+//can't be walked.
+func (s *Set) Names() []string {
+	pages, err := s.loaded(<-modeChan)
+	if err != nil {
+		return nil
+	}
+	return sortedPages(pages)
+}
+
+//sortedPages returns the names of the pages in order.
+func sortedPages(pages map[string]*Template) []string {
+	names := make([]string, 0, len(pages))
+	for name := range pages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Lookup returns the page with the name, or nil if there is no such page or the
+//tree can't be walked.
+func (s *Set) Lookup(name string) *Template {
+	pages, err := s.loaded(<-modeChan)
+	if err != nil {
+		return nil
+	}
+	return pages[name]
+}
+
+//Execute runs the page with the name like Template.Execute.
+func (s *Set) Execute(w io.Writer, name string, data interface{}, globs ...string) (err error) {
+	return s.ExecuteContext(gocontext.Background(), w, name, data, globs...)
+}
+
+//ExecuteContext runs the page with the name under the given context.Context
+//like Template.ExecuteContext.
+func (s *Set) ExecuteContext(ctx gocontext.Context, w io.Writer, name string, data interface{}, globs ...string) (err error) {
+	pages, err := s.loaded(<-modeChan)
+	if err != nil {
+		return
+	}
+	t, ex := pages[name]
+	if !ex {
+		return fmt.Errorf("no page by the name %s", name)
+	}
+	return t.ExecuteContext(ctx, w, data, globs...)
+}
@@ -0,0 +1,145 @@
+package tmpl
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var setFiles = []templateFile{
+	{"layout.tmpl", `<{% block content %}{% end block %}>`},
+	{"index.tmpl", `{% evoke header %}`},
+	{"users/show.tmpl", `{% extends "../layout.tmpl" %}{% block content %}{% evoke header %}|{% call shout .Name %}|{% evoke row %}{% end block %}`},
+	{"users/row.block", `{% block row %}{% include "note.txt" %}{% end block %}`},
+	{"blocks/header.block", `{% block header %}head{% end block %}`},
+	{"users/note.txt", `note`},
+}
+
+func testSet(t *testing.T, set *Set) {
+	set.Call("shout", strings.ToUpper)
+	if err := set.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{"index", "layout", "users/show"}
+	if names := set.Names(); !reflect.DeepEqual(names, exp) {
+		t.Errorf("Expected %v got %v", exp, names)
+	}
+	if set.Lookup("users/show") == nil {
+		t.Error("Expected to find users/show")
+	}
+	if set.Lookup("users/row") != nil {
+		t.Error("Expected partials not to be pages")
+	}
+
+	cases := []struct {
+		name string
+		exp  string
+	}{
+		{"index", "head"},
+		{"layout", "<>"},
+		{"users/show", "<head|BOB|note>"},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := set.Execute(&buf, c.name, map[string]string{"Name": "bob"}); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got := buf.String(); got != c.exp {
+			t.Errorf("%s:\nExp %q\nGot %q", c.name, c.exp, got)
+		}
+	}
+
+	if err := set.Execute(ioutil.Discard, "nope", nil); err == nil {
+		t.Error("Expected an error for a missing page")
+	}
+}
+
+func TestSetDir(t *testing.T) {
+	dir := createTestDir(t, setFiles)
+	defer os.RemoveAll(dir)
+	testSet(t, NewSet(dir))
+}
+
+func TestSetFS(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for _, file := range setFiles {
+		fsys[file.name] = &fstest.MapFile{Data: []byte(file.contents)}
+	}
+	testSet(t, NewSetFS(fsys))
+
+	//names starting with a slash are rooted at the file system
+	fsys["admin/home.tmpl"] = &fstest.MapFile{Data: []byte(`{% extends "/layout.tmpl" %}{% block content %}admin{% end block %}`)}
+	var out bytes.Buffer
+	if err := NewSetFS(fsys).Execute(&out, "admin/home", nil); err != nil || out.String() != "<admin>" {
+		t.Errorf("Expected %q got %q: %v", "<admin>", out.String(), err)
+	}
+
+	//front matter is only read when the set asks for it
+	fsys["meta.tmpl"] = &fstest.MapFile{Data: []byte("---\ntitle: Meta\n---\n{% /.@meta.title %}")}
+	out.Reset()
+	if err := NewSetFS(fsys).FrontMatter().Execute(&out, "meta", nil); err != nil || out.String() != "Meta" {
+		t.Errorf("Expected %q got %q: %v", "Meta", out.String(), err)
+	}
+}
+
+func TestSetFailures(t *testing.T) {
+	fsys := fstest.MapFS{
+		"page.html":   {Data: []byte(`{% evoke a %}`)},
+		"one.partial": {Data: []byte(`{% block a %}1{% end block %}`)},
+		"two.partial": {Data: []byte(`{% block a %}2{% end block %}`)},
+		"other.tmpl":  {Data: []byte(`{% if %}`)},
+	}
+	set := NewSetFS(fsys).Extensions(".html", ".partial")
+	if err := set.Compile(); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Expected an error for a block in two partials got %v", err)
+	}
+
+	delete(fsys, "two.partial")
+	set = NewSetFS(fsys).Extensions(".html", ".partial")
+	if names := set.Names(); !reflect.DeepEqual(names, []string{"page"}) {
+		t.Errorf("Expected only the page got %v", names)
+	}
+	var out bytes.Buffer
+	if err := set.Execute(&out, "page", nil); err != nil || out.String() != "1" {
+		t.Errorf("Expected %q got %q: %v", "1", out.String(), err)
+	}
+}
+
+func TestSetPartialsUnderPages(t *testing.T) {
+	fsys := fstest.MapFS{
+		"layout.tmpl": {Data: []byte(`<{% block title %}layout{% end block %}|{% evoke nav %}>`)},
+		"page.tmpl":   {Data: []byte(`{% extends "layout.tmpl" %}{% block title %}page{% end block %}`)},
+		"super.tmpl":  {Data: []byte(`{% extends "layout.tmpl" %}{% block nav %}{% super %}+{% end block %}`)},
+		"parts.block": {Data: []byte(`{% block title %}partial{% end block %}{% block nav %}nav{% end block %}`)},
+		"direct.tmpl": {Data: []byte(`[{% block nav %}own{% end block %}]`)},
+	}
+	set := NewSetFS(fsys)
+
+	//the page and its layout replace the blocks of the partials, and build on
+	//them with super
+	cases := []struct {
+		name string
+		exp  string
+	}{
+		{"layout", "<layout|nav>"},
+		{"page", "<page|nav>"},
+		{"super", "<layout|nav+>"},
+		{"direct", "[own]"},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := set.Execute(&buf, c.name, nil); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got := buf.String(); got != c.exp {
+			t.Errorf("%s:\nExp %q\nGot %q", c.name, c.exp, got)
+		}
+	}
+}
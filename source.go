@@ -0,0 +1,159 @@
+package tmpl
+
+import (
+	"io/fs"
+	"io/ioutil"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+//source is where a template reads its files from. Files are known by the
+//names abs gives them, which the parse trees are cached under.
+type source interface {
+	//abs returns the name the source knows the file by
+	abs(file string) (string, error)
+
+	//rel returns the name of the file that name, referenced from within
+	//file, refers to
+	rel(file, name string) string
+
+	glob(pattern string) ([]string, error)
+	read(file string) ([]byte, error)
+
+	//tree returns the parse tree for the file, reading its front matter if
+	//front is set, and grabbing it from the cache in Production mode
+	tree(abs string, front bool, mode Mode) (*parseTree, error)
+
+	//walk calls fn with every file under root and its path from root, using
+	//forward slashes
+	walk(root string, fn func(file, name string) error) error
+}
+
+//osSource reads files from the operating system, caching them for every
+//template in the package.
+type osSource struct{}
+
+func (osSource) abs(file string) (string, error) {
+	return filepath.Abs(file)
+}
+
+//rel treats relative names as relative to the directory file is in.
+func (osSource) rel(file, name string) string {
+	if filepath.IsAbs(name) {
+		return filepath.Clean(name)
+	}
+	return filepath.Join(filepath.Dir(file), name)
+}
+
+func (osSource) glob(pattern string) ([]string, error) {
+	return filepath.Glob(pattern)
+}
+
+func (osSource) read(file string) ([]byte, error) {
+	return ioutil.ReadFile(file)
+}
+
+func (s osSource) tree(abs string, front bool, mode Mode) (tree *parseTree, err error) {
+	flocks.Lock(abs)
+	defer flocks.Unlock(abs)
+
+	key := treeKey{abs, front}
+	if mode == Production {
+		//check for the cache
+		if tr, ex := cache[key]; ex {
+			tree = tr
+			return
+		}
+	}
+	tree, err = parseFile(s, abs, front)
+	if err != nil {
+		return
+	}
+	cache[key] = tree
+	return
+}
+
+func (osSource) walk(root string, fn func(file, name string) error) error {
+	return filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		return fn(file, filepath.ToSlash(name))
+	})
+}
+
+//fsSource reads files from an fs.FS, caching them for the templates that
+//share it. Names are always relative to the root of the file system, so a
+//name starting with a slash is rooted there.
+type fsSource struct {
+	fsys  fs.FS
+	cache map[treeKey]*parseTree
+	lk    sync.Mutex
+}
+
+func newFSSource(fsys fs.FS) *fsSource {
+	return &fsSource{
+		fsys:  fsys,
+		cache: map[treeKey]*parseTree{},
+	}
+}
+
+func (s *fsSource) abs(file string) (string, error) {
+	if abs := pathpkg.Clean("/" + file)[1:]; abs != "" {
+		return abs, nil
+	}
+	return ".", nil
+}
+
+func (s *fsSource) rel(file, name string) string {
+	if !pathpkg.IsAbs(name) {
+		name = pathpkg.Join(pathpkg.Dir(file), name)
+	}
+	abs, _ := s.abs(name)
+	return abs
+}
+
+func (s *fsSource) glob(pattern string) ([]string, error) {
+	return fs.Glob(s.fsys, strings.TrimPrefix(pattern, "/"))
+}
+
+func (s *fsSource) read(file string) ([]byte, error) {
+	return fs.ReadFile(s.fsys, file)
+}
+
+func (s *fsSource) tree(abs string, front bool, mode Mode) (tree *parseTree, err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	key := treeKey{abs, front}
+	if mode == Production {
+		if tr, ex := s.cache[key]; ex {
+			return tr, nil
+		}
+	}
+	tree, err = parseFile(s, abs, front)
+	if err != nil {
+		return
+	}
+	s.cache[key] = tree
+	return
+}
+
+func (s *fsSource) walk(root string, fn func(file, name string) error) error {
+	return fs.WalkDir(s.fsys, root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name := file
+		if root != "." {
+			name = strings.TrimPrefix(file, root+"/")
+		}
+		return fn(file, name)
+	})
+}
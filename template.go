@@ -1,11 +1,12 @@
 package tmpl
 
 import (
+	gocontext "context"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"path/filepath"
+	"os"
 	"reflect"
+	"strings"
 	"sync"
 )
 
@@ -55,12 +56,20 @@ func CompileMode(mode Mode) {
 	modeChange <- mode
 }
 
-var cache = map[string]*parseTree{}
+var cache = map[treeKey]*parseTree{}
+
+//treeKey is what parse trees are cached under, as a file parsed with front
+//matter has a different tree than the same file parsed without it.
+type treeKey struct {
+	abs   string
+	front bool
+}
 
 func newTemplate(file string) *Template {
 	return &Template{
 		base:  file,
 		dirty: true,
+		src:   osSource{},
 	}
 }
 
@@ -78,6 +87,19 @@ type Template struct {
 	funcs []funcDecl
 	dirty bool
 
+	//the files of blocks layered under the base template and the files it
+	//extends, which can't replace each others blocks
+	partials []string
+
+	//where the files are read from
+	src source
+
+	//the built in functions allowed, or nil for all of them
+	builtins map[string]bool
+
+	//if the files start with front matter
+	front bool
+
 	compileLk sync.RWMutex
 
 	//our parse tree
@@ -95,17 +117,110 @@ func (t *Template) Blocks(globs ...string) *Template {
 
 //Call attaches a function to the template under the specified name for every
 //Execute call so the base template can call them. The second argument must
-//be a function, or Call will panic.
+//be a function, or Call will panic. If the first parameter of the function is
+//a context.Context, the context passed to ExecuteContext is handed to it
+//automatically and the call's arguments fill the remaining parameters.
+//
+//The function must return a single value, optionally followed by an error.
+//If the function returns a non nil error, the Execute fails with it. Call
+//panics if the function returns anything else.
 func (t *Template) Call(name string, fnc interface{}) *Template {
+	t.funcs = append(t.funcs, funcDecl{name, funcValue(name, fnc)})
+	t.dirty = true
+	return t
+}
+
+//funcValue returns the reflect value of a function to be attached under the
+//name, panicing if it isn't a function that can be called from a template.
+func funcValue(name string, fnc interface{}) reflect.Value {
 	rv := reflect.ValueOf(fnc)
 	if rv.Kind() != reflect.Func {
 		panic(fmt.Errorf("%q is not a function.", fnc))
 	}
-	t.funcs = append(t.funcs, funcDecl{name, rv})
+	if err := checkFunc(rv.Type()); err != nil {
+		panic(fmt.Errorf("%q: %v", name, err))
+	}
+	return rv
+}
+
+var (
+	globalFuncs   = map[string]reflect.Value{}
+	globalFuncsLk sync.RWMutex
+)
+
+//Funcs attaches the functions to every Template under the names they are
+//keyed by, so that helpers used everywhere don't have to be attached to each
+//Template with Call. Functions attached with Call take precedence over these,
+//which in turn take precedence over the built in functions. Funcs may be called
+//at any time, and the functions are available to the next call that needs
+//them. The values must follow the same rules as Call, or Funcs will panic.
+func Funcs(funcs map[string]interface{}) {
+	vals := map[string]reflect.Value{}
+	for name, fnc := range funcs {
+		vals[name] = funcValue(name, fnc)
+	}
+
+	globalFuncsLk.Lock()
+	defer globalFuncsLk.Unlock()
+	for name, val := range vals {
+		globalFuncs[name] = val
+	}
+}
+
+//globalFunc returns the function attached with Funcs under the name.
+func globalFunc(name string) (fnc reflect.Value, ex bool) {
+	globalFuncsLk.RLock()
+	defer globalFuncsLk.RUnlock()
+	fnc, ex = globalFuncs[name]
+	return
+}
+
+//Builtins restricts the built in functions available to the template to the
+//given names. Calling Builtins with no names disables the built in functions
+//entirely. Functions attached with Call are unaffected, and always take
+//precedence over a built in function of the same name. Builtins panics if a
+//name is not a built in function.
+func (t *Template) Builtins(names ...string) *Template {
+	t.builtins = builtinSet(names)
 	t.dirty = true
 	return t
 }
 
+//FrontMatter makes the template read the front matter from the top of every
+//file it loads. Without it, files are used as is, so that a template may start
+//with text that only looks like front matter, like a JSON object.
+func (t *Template) FrontMatter() *Template {
+	t.front = true
+	t.dirty = true
+	return t
+}
+
+//builtinSet returns the set of built in functions with the names, panicing if
+//one of them isn't a built in function.
+func builtinSet(names []string) map[string]bool {
+	set := map[string]bool{}
+	for _, name := range names {
+		if _, ex := builtins[name]; !ex {
+			panic(fmt.Errorf("%q is not a built in function.", name))
+		}
+		set[name] = true
+	}
+	return set
+}
+
+//availableBuiltins returns the built in functions the template is allowed to
+//call.
+func (t *Template) availableBuiltins() map[string]reflect.Value {
+	if t.builtins == nil {
+		return builtins
+	}
+	avail := map[string]reflect.Value{}
+	for name := range t.builtins {
+		avail[name] = builtins[name]
+	}
+	return avail
+}
+
 func (t *Template) compile(mode Mode) (err error) {
 	if err = t.updateBase(mode); err != nil {
 		return
@@ -113,6 +228,7 @@ func (t *Template) compile(mode Mode) (err error) {
 	if err = t.updateGlobs(t.globs, mode); err != nil {
 		return
 	}
+	t.tree.context.builtins = t.availableBuiltins()
 	for _, decl := range t.funcs {
 		t.tree.context.funcs[decl.name] = decl.val
 	}
@@ -120,46 +236,149 @@ func (t *Template) compile(mode Mode) (err error) {
 	return
 }
 
-func parseFile(file string) (tree *parseTree, err error) {
-	data, err := ioutil.ReadFile(file)
+func parseFile(src source, file string, front bool) (tree *parseTree, err error) {
+	data, err := src.read(file)
 	if err != nil {
 		return
 	}
-	tree, err = parse(lex(data))
+	var meta map[string]interface{}
+	line := 0
+	if front {
+		if meta, data, line, err = frontMatter(data); err != nil {
+			return
+		}
+	}
+	tree, err = parse(lexAt(data, line))
 	if err != nil {
 		return
 	}
 	tree.context.setFile(file)
+	tree.file = file
+	tree.meta = meta
+	for _, inc := range tree.includes {
+		inc.abs = src.rel(file, inc.name)
+	}
 	return
 }
 
-//treeFor grabs the parseTree for the specified absolute path, grabbing it from
-//the cache if t.compiled is true
-func (t *Template) treeFor(abs string, mode Mode) (tree *parseTree, err error) {
-	flocks.Lock(abs)
-	defer flocks.Unlock(abs)
+//extendsChain returns the tree for the base template followed by the trees of
+//every template it extends, from the most derived to the least.
+func (t *Template) extendsChain(mode Mode) (chain []*parseTree, err error) {
+	abs, err := t.src.abs(t.base)
+	if err != nil {
+		return
+	}
+	files := []string{abs}
+	for {
+		tree, err := t.src.tree(abs, t.front, mode)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, tree)
+		if tree.extends == "" {
+			return chain, nil
+		}
 
-	if mode == Production {
-		//check for the cache
-		if tr, ex := cache[abs]; ex {
-			tree = tr
-			return
+		//find the parent and make sure we haven't seen it before
+		abs = t.src.rel(abs, tree.extends)
+		for _, file := range files {
+			if file == abs {
+				files = append(files, abs)
+				return nil, fmt.Errorf("extends cycle: %s", strings.Join(files, " -> "))
+			}
 		}
+		files = append(files, abs)
 	}
-	tree, err = parseFile(abs)
+}
+
+func (t *Template) updateBase(mode Mode) (err error) {
+	chain, err := t.extendsChain(mode)
 	if err != nil {
 		return
 	}
-	cache[abs] = tree
+
+	//work on a copy so the cached trees are left alone for other templates,
+	//rendering the output of the least derived template
+	root := chain[len(chain)-1]
+	t.tree = &parseTree{
+		base:    root.base,
+		context: root.context.clone(),
+		file:    root.file,
+	}
+
+	//the partials are the lowest layer, so the templates can replace them
+	t.tree.context.blocks = map[string]*executeBlockValue{}
+	t.tree.context.includes = map[string]*parseTree{}
+	if err = t.updateFiles(t.partials, mode); err != nil {
+		return
+	}
+
+	//layer the blocks and front matter of each template over the template
+	//it extends so the most derived definition wins
+	t.tree.meta = map[string]interface{}{}
+	for i := len(chain) - 1; i >= 0; i-- {
+		for key, val := range chain[i].meta {
+			t.tree.meta[key] = val
+		}
+		for _, bl := range chain[i].context.blocks {
+			t.tree.context.override(bl, bl.file)
+		}
+		for id, m := range chain[i].context.macros {
+			t.tree.context.macros[id] = m
+		}
+	}
+
+	t.tree.context.meta = t.tree.meta
+
+	//load the files they include
+	for _, tree := range chain {
+		if err = t.loadIncludes(tree, mode, []string{tree.file}); err != nil {
+			return
+		}
+	}
 	return
 }
 
-func (t *Template) updateBase(mode Mode) (err error) {
-	abs, err := filepath.Abs(t.base)
-	if err != nil {
-		return
+//loadIncludes loads the files included by tree, and the files they include in
+//turn, adding any blocks they define that the template doesn't. The files
+//leading to tree are in seen to catch cycles.
+func (t *Template) loadIncludes(tree *parseTree, mode Mode, seen []string) (err error) {
+	for _, inc := range tree.includes {
+		for _, file := range seen {
+			if file == inc.abs {
+				files := append(seen[:len(seen):len(seen)], inc.abs)
+				return fmt.Errorf("include cycle: %s", strings.Join(files, " -> "))
+			}
+		}
+		if _, ex := t.tree.context.includes[inc.abs]; ex {
+			continue
+		}
+
+		included, err := t.src.tree(inc.abs, t.front, mode)
+		if err != nil {
+			if inc.ignore && os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if included.extends != "" {
+			return fmt.Errorf("%q: an included file can't extend another", inc.abs)
+		}
+		t.tree.context.includes[inc.abs] = included
+		for _, bl := range included.context.blocks {
+			t.tree.context.fallback(bl, included.file)
+		}
+		for id, m := range included.context.macros {
+			if _, ex := t.tree.context.macros[id]; !ex {
+				t.tree.context.macros[id] = m
+			}
+		}
+
+		err = t.loadIncludes(included, mode, append(seen[:len(seen):len(seen)], inc.abs))
+		if err != nil {
+			return err
+		}
 	}
-	t.tree, err = t.treeFor(abs, mode)
 	return
 }
 
@@ -174,12 +393,20 @@ func (t *Template) updateGlobs(globs []string, mode Mode) (err error) {
 }
 
 func (t *Template) updateGlob(glob string, mode Mode) (err error) {
-	files, err := filepath.Glob(glob)
+	files, err := t.src.glob(glob)
 	if err != nil {
 		return
 	}
+	err = t.updateFiles(files, mode)
+	return
+}
+
+//updateFiles attaches the blocks in the files to the template. Files loaded
+//together can't replace each others blocks.
+func (t *Template) updateFiles(files []string, mode Mode) (err error) {
+	defined := map[string]string{}
 	for _, file := range files {
-		err = t.loadBlocks(file, mode)
+		err = t.loadBlocks(file, mode, defined)
 		if err != nil {
 			return
 		}
@@ -187,28 +414,62 @@ func (t *Template) updateGlob(glob string, mode Mode) (err error) {
 	return
 }
 
-func (t *Template) loadBlocks(file string, mode Mode) (err error) {
-	abs, err := filepath.Abs(file)
+func (t *Template) loadBlocks(file string, mode Mode, defined map[string]string) (err error) {
+	abs, err := t.src.abs(file)
 	if err != nil {
 		return
 	}
-	tree, err := t.treeFor(abs, mode)
+	tree, err := t.src.tree(abs, t.front, mode)
 	if err != nil {
 		return
 	}
-	err = t.updateBlocks(file, tree.context.blocks)
+	if tree.extends != "" {
+		err = fmt.Errorf("%q: only a base template can extend another", file)
+		return
+	}
+	if err = t.updateBlocks(file, tree.context.blocks, defined); err != nil {
+		return
+	}
+	if err = t.updateMacros(file, tree.context.macros, defined); err != nil {
+		return
+	}
+	err = t.loadIncludes(tree, mode, []string{abs})
 	return
 }
 
-func (t *Template) updateBlocks(file string, blocks map[string]*executeBlockValue) (err error) {
-	tblk := t.tree.context.blocks
+//updateBlocks attaches the blocks defined in file to the template, replacing
+//the definitions from the base template and the files it extends. The blocks
+//must not already be defined by another file in defined, and can only build
+//on a block attached from another glob, with super or by appending or
+//prepending, rather than replace it.
+func (t *Template) updateBlocks(file string, blocks map[string]*executeBlockValue, defined map[string]string) (err error) {
 	for id, bl := range blocks {
-		if _, ex := tblk[id]; ex {
-			err = fmt.Errorf("%q: %q already exists from %q", file, id, bl.file)
+		if prev, ex := defined[id]; ex {
+			err = fmt.Errorf("%q: %q already exists from %q", file, id, prev)
+			return
+		}
+		prev := t.tree.context.blocks[id]
+		if prev != nil && prev.attached && bl.mode == blockReplace && !bl.super {
+			err = fmt.Errorf("%q: %q already exists from %q", file, id, prev.file)
 			return
 		}
-		bl.file = file
-		tblk[id] = bl
+		defined[id] = file
+		t.tree.context.override(bl, file).attached = true
+	}
+	return
+}
+
+//updateMacros attaches the macros defined in file to the template like
+//updateBlocks does for blocks.
+func (t *Template) updateMacros(file string, macros map[string]*executeMacro, defined map[string]string) (err error) {
+	for id, m := range macros {
+		key := "macro " + id
+		if prev, ex := defined[key]; ex {
+			err = fmt.Errorf("%q: macro %q already exists from %q", file, id, prev)
+			return
+		}
+		defined[key] = file
+		t.tree.context.macros[id] = m
 	}
 	return
 }
@@ -245,6 +506,14 @@ func (t *Template) lockedUpdateGlobs(globs []string, mode Mode) (err error) {
 //(see the discussion on Modes) or during the execution of the template are
 //returned.
 func (t *Template) Execute(w io.Writer, ctx interface{}, globs ...string) (err error) {
+	return t.ExecuteContext(gocontext.Background(), w, ctx, globs...)
+}
+
+//ExecuteContext is like Execute, but runs the template under the given
+//context.Context. The execution is abandoned with the context's error as soon
+//as it is cancelled or its deadline passes, and it is handed to any attached
+//function whose first parameter is a context.Context.
+func (t *Template) ExecuteContext(ctx gocontext.Context, w io.Writer, data interface{}, globs ...string) (err error) {
 	//grab the mode for this execute
 	mode := <-modeChan
 
@@ -272,7 +541,38 @@ func (t *Template) Execute(w io.Writer, ctx interface{}, globs ...string) (err e
 	//execute!
 	t.compileLk.RLock()
 	defer t.compileLk.RUnlock()
-	return t.tree.Execute(w, ctx)
+	return t.tree.ExecuteContext(ctx, w, data)
+}
+
+//compiled compiles the template if Execute would, so that it can be inspected
+//without being executed.
+func (t *Template) compiled() (err error) {
+	mode := <-modeChan
+	if mode == Development || t.dirty {
+		err = t.runCompilation(nil, mode)
+	}
+	return
+}
+
+//Compile loads and parses the base template, every template it extends or
+//includes, and every file matching the globs attached with Blocks, returning
+//the first error found.
+func (t *Template) Compile() (err error) {
+	return t.runCompilation(nil, <-modeChan)
+}
+
+//Meta returns the values in the front matter of the base template, layered
+//over the front matter of the templates it extends so the most derived value
+//for each key wins. The returned map is shared by every execution and must
+//not be modified. It is empty unless the template reads front matter with
+//FrontMatter.
+func (t *Template) Meta() (meta map[string]interface{}, err error) {
+	if err = t.compiled(); err != nil {
+		return
+	}
+	t.compileLk.RLock()
+	defer t.compileLk.RUnlock()
+	return t.tree.meta, nil
 }
 
 //Parse creates a new Template with the specified file acting as the base
@@ -281,3 +581,14 @@ func Parse(file string) (t *Template) {
 	t = newTemplate(file)
 	return
 }
+
+//MustParse creates a new Template with the specified file acting as the base
+//template and the block definitions in the files that match the globs
+//attached, and compiles it, panicing if there are any errors.
+func MustParse(file string, globs ...string) (t *Template) {
+	t = Parse(file).Blocks(globs...)
+	if err := t.Compile(); err != nil {
+		panic(err)
+	}
+	return
+}
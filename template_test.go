@@ -2,7 +2,9 @@ package tmpl
 
 import (
 	"bytes"
+	gocontext "context"
 	"io/ioutil"
+	"reflect"
 	"testing"
 )
 
@@ -26,7 +28,7 @@ func (s *s) String() string {
 func TestTemplateNoContext(t *testing.T) {
 	executeTemplatePasses(t, []templatePassCase{
 		{`this is just a literal`, nil, `this is just a literal`},
-		{`{% block foo %}test{% end block %}{% evoke foo %}`, nil, `test`},
+		{`{% block foo %}test{% end block %}{% evoke foo %}`, nil, `testtest`},
 		{`{# foo #}test`, nil, `test`},
 		{`{# foo #}test{# bar baz #}`, nil, `test`},
 	})
@@ -43,8 +45,252 @@ func TestTemplatePassBlocks(t *testing.T) {
 		{
 			`{% block foo %}{% .foo %}{% .bar %}{% end block %}{% evoke foo %}`,
 			d{"foo": "foo", "bar": "bar"},
-			`foobar`,
+			`foobarfoobar`,
+		},
+		{`<{% block foo %}default{% end block %}>`, nil, `<default>`},
+		{`{% with .foo %}{% block foo %}{% . %}{% end block %}{% end with %}`, d{"foo": "bar"}, `bar`},
+	})
+}
+
+func TestTemplatePassStacks(t *testing.T) {
+	executeTemplatePasses(t, []templatePassCase{
+		{`<{% stack js %}>{% push js %}a{% end push %}{% push js %}b{% end push %}`, nil, `<ab>`},
+		{`{% push js %}a{% end push %}<{% stack js %}>`, nil, `<a>`},
+		{`<{% stack js %}>`, nil, `<>`},
+		{`{% stack js %}|{% stack css %}{% push css %}c{% end push %}{% push js %}j{% end push %}`, nil, `j|c`},
+		{`<{% stack js %}>{% range . %}{% push js once %}x{% end push %}{% end range %}`, []int{1, 2, 3}, `<x>`},
+		{`<{% stack js %}>{% range . as _ v %}{% push js %}{% .v %}{% end push %}{% end range %}`, []int{1, 2, 3}, `<123>`},
+		{`{% block foo %}{% push js %}foo{% end push %}{% end block %}[{% stack js %}]`, nil, `[foo]`},
+		{`{% stack js %}{% . %}`, "\x00stack:js\x00", "\x00stack:js\x00"},
+		{`{% stack a %}{% stack b %}{% stack c %}{% stack a %}{% push c %}3{% end push %}{% push b %}2{% end push %}{% push a %}1{% end push %}`, nil, `1231`},
+	})
+}
+
+func TestTemplateFailStacks(t *testing.T) {
+	executeTemplateFails(t, []templateFailCase{
+		{`{% capture x %}{% stack js %}{% end capture %}`, nil},
+		{`{% push css %}{% stack js %}{% end push %}`, nil},
+	})
+}
+
+func TestTemplatePassNamedArgs(t *testing.T) {
+	executeTemplatePasses(t, []templatePassCase{
+		{
+			`{% block button as label href %}{% if .label %}<a href="{% .href %}">{% .label %}</a>{% end if %}{% end block %}|{% evoke button label=.Title href=.URL %}`,
+			d{"Title": "Home", "URL": "/", "label": "x", "href": "y"},
+			`|<a href="/">Home</a>`,
+		},
+		{
+			`{% block b as x %}{% if .x %}{% .x %}{% else %}none{% end if %}{% end block %}|{% evoke b x="a" %}{% evoke b x=1 %}`,
+			d{"x": "d"},
+			`none|a1`,
+		},
+		{
+			`{% block b as x %}{% .y %}{% if .x %}{% .x %}{% end if %}{% end block %}|{% evoke b .inner x=.y %}|{% .y %}`,
+			d{"y": "outer", "inner": d{"y": "inner"}},
+			`outer|innerouter|outer`,
+		},
+		{
+			`{% block b as x %}{% if .x %}{% .x %}{% end if %}{% end block %}{% range . as _ v %}{% evoke b x=.v %}{% end range %}`,
+			[]string{"a", "b"},
+			`ab`,
+		},
+		{
+			`{% block b as x %}{% if .x %}{% .x %}{% end if %}{% end block %}{% evoke b x=call upper "a" %}`,
+			nil,
+			`A`,
+		},
+	})
+}
+
+func TestTemplatePassSlots(t *testing.T) {
+	executeTemplatePasses(t, []templatePassCase{
+		{`{% block card %}[{% slot %}]{% end block %}|{% evoke card %}body{% end evoke %}`, nil, `[]|[body]`},
+		{
+			`{% block card %}{% .Name %}:{% slot %}{% end block %}|{% evoke card .Item %}{% .Title %}{% end evoke %}`,
+			d{"Name": "page", "Title": "caller", "Item": d{"Name": "item"}},
+			`page:|item:caller`,
+		},
+		{
+			`{% block card %}<{% slot %}|{% slot footer %}>{% end block %}{% evoke card %}a{% fill footer %}f{% end fill %}b{% end evoke %}`,
+			nil,
+			`<|><ab|f>`,
+		},
+		{
+			`{% block card %}({% slot %}){% end block %}{% evoke card %}a{% evoke card %}b{% end evoke %}c{% end evoke %}`,
+			nil,
+			`()(a(b)c)`,
+		},
+		{
+			`{% block outer %}<{% evoke inner %}{% slot %}{% end evoke %}>{% end block %}{% block inner %}[{% slot %}]{% end block %}|{% evoke outer %}x{% end evoke %}`,
+			nil,
+			`<[]>[]|<[x]>`,
+		},
+		{
+			`{% block card as label %}{% if .label %}{% .label %}={% slot %}{% end if %}{% end block %}{% evoke card label="l" %}{% .v %}{% end evoke %}`,
+			d{"v": "caller"},
+			`l=caller`,
 		},
+		{`{% block card %}[{% slot %}]{% end block %}|{% evoke card %}`, nil, `[]|[]`},
+		{`{% block card %}[{% slot %}]{% end block %}{% block body %}b{% end block %}|{% evoke card %}{% evoke card %}x{% end evoke %}{% evoke body %}`, nil, `[]b|[][x]b`},
+		{`{% block a %}A{% end block %}{% if . %}{% evoke a %}{% else %}-{% end if %}|{% evoke a %}x`, true, `AA|Ax`},
+	})
+}
+
+func TestTemplatePassDynamicEvoke(t *testing.T) {
+	type kind string
+	executeTemplatePasses(t, []templatePassCase{
+		{
+			`{% block text %}{% if .Body %}<p>{% .Body %}</p>{% end if %}{% end block %}{% block image %}{% if .Src %}<img src="{% .Src %}">{% end if %}{% end block %}{% range .Widgets as _ w %}{% evoke (.w.Kind) .w %}{% end range %}`,
+			d{"Widgets": []d{{"Kind": "text", "Body": "hi"}, {"Kind": "image", "Src": "a.png"}}},
+			`<p>hi</p><img src="a.png">`,
+		},
+		{`{% block foo %}foo{% end block %}|{% evoke call lower "FOO" %}`, nil, `foo|foo`},
+		{`{% block foo %}foo{% end block %}|{% evoke (call lower .) %}`, "FOO", `foo|foo`},
+		{`[{% evoke? sidebar %}]`, nil, `[]`},
+		{`[{% evoke? (.) %}]`, "sidebar", `[]`},
+		{`{% block sidebar %}s{% end block %}[{% evoke? sidebar %}]`, nil, `s[s]`},
+		{`{% call upper (call lower "A") %}`, nil, `A`},
+		{`{% block text %}t{% end block %}|{% evoke (.Kind) %}`, d{"Kind": kind("text")}, `t|t`},
+	})
+}
+
+func TestTemplateFailDynamicEvoke(t *testing.T) {
+	executeTemplateFails(t, []templateFailCase{
+		{`{% evoke (.) %}`, "missing"},
+		{`{% evoke (.) %}`, 5},
+		{`{% evoke (.missing) %}`, nil},
+	})
+}
+
+func TestTemplatePassMacros(t *testing.T) {
+	executeTemplatePasses(t, []templatePassCase{
+		{`{% macro price amount currency %}<span>{% .amount %}-{% .currency %}</span>{% end macro %}{% call price .Price "USD" %}`, d{"Price": 5}, `<span>5-USD</span>`},
+		{`{% call greet "bob" %}{% macro greet name %}hi {% .name %}{% end macro %}`, nil, `hi bob`},
+		{`{% macro m %}{% $.x %}{% end macro %}{% with .a %}{% call m %}{% end with %}`, d{"a": d{"x": "inner"}}, `inner`},
+		{`{% macro m %}{% end macro %}[{% call m %}]`, nil, `[]`},
+		{`{% macro upper s %}up {% .s %}{% end macro %}{% call upper "x" %}`, nil, `up x`},
+		{`{% macro em s %}*{% .s %}*{% end macro %}{% call upper (call em "x") %}`, nil, `*X*`},
+		{`{% macro twice s %}{% .s %}{% .s %}{% end macro %}{% if call eq (call twice "a") "aa" %}yes{% end if %}`, nil, `yes`},
+		{`{% macro list xs %}{% range .xs as _ x %}{% .x %}{% end range %}{% end macro %}{% call list . %}`, []int{1, 2}, `12`},
+	})
+}
+
+func TestTemplateFailMacros(t *testing.T) {
+	executeTemplateFails(t, []templateFailCase{
+		{`{% macro m a %}{% end macro %}{% call m %}`, nil},
+		{`{% macro m %}{% end macro %}{% call m 1 %}`, nil},
+		{`{% macro m %}{% .missing %}{% end macro %}{% call m %}`, nil},
+		{`{% macro m %}{% call m %}{% end macro %}{% call m %}`, nil},
+	})
+}
+
+func TestTemplatePassCapture(t *testing.T) {
+	executeTemplatePasses(t, []templatePassCase{
+		{`{% capture title %}<b>{% .Name %}</b>{% end capture %}{% .title %}|{% .title %}`, d{"Name": "x"}, `<b>x</b>|<b>x</b>`},
+		{`{% capture s %}ab{% end capture %}{% call upper .s %}`, nil, `AB`},
+		{`{% capture s %}{% end capture %}[{% .s %}]`, nil, `[]`},
+		{`{% capture x %}new{% end capture %}{% .x %}-{% if . %}{% capture x %}inner{% end capture %}{% .x %}{% end if %}-{% .x %}`, d{"x": "old"}, `new-inner-new`},
+		{`{% if . %}{% capture x %}inner{% end capture %}{% .x %}{% end if %}-{% .x %}`, d{"x": "old"}, `inner-old`},
+		{`{% if 1 %}{% capture x %}inner{% end capture %}{% end if %}[{% .x %}]`, d{"x": "old"}, `[old]`},
+		{`{% switch 1 %}{% case 1 %}{% capture x %}inner{% end capture %}{% end switch %}[{% .x %}]`, d{"x": "old"}, `[old]`},
+		{`{% range . as _ v %}{% capture x %}<{% .v %}>{% end capture %}{% .x %}{% end range %}`, []int{1, 2}, `<1><2>`},
+		{`{% capture x %}{% push js %}j{% end push %}{% end capture %}[{% stack js %}]`, nil, `[j]`},
+	})
+}
+
+func TestTemplatePassSwitch(t *testing.T) {
+	type status string
+	tmpl := `{% switch .Status %}
+		{% case "paid" "settled" %}done
+		{% case "refunded" %}back
+		{% default %}pending
+	{% end switch %}`
+	executeTemplatePasses(t, []templatePassCase{
+		{tmpl, d{"Status": "paid"}, "done\n\t\t"},
+		{tmpl, d{"Status": status("settled")}, "done\n\t\t"},
+		{tmpl, d{"Status": "refunded"}, "back\n\t\t"},
+		{tmpl, d{"Status": "new"}, "pending\n\t"},
+		{tmpl, d{}, "pending\n\t"},
+		{`{% switch . %}{% case 1 %}one{% case 2 %}two{% end switch %}`, uint8(2), `two`},
+		{`{% switch . %}{% case 1 %}one{% case 2 %}two{% end switch %}`, 2.0, `two`},
+		{`{% switch . %}{% case 1 %}one{% end switch %}`, "1", ``},
+		{`{% switch . %}{% case .a %}a{% case .b %}b{% end switch %}`, d{"a": 1, "b": 2}, ``},
+		{`{% switch .x %}{% case .a %}a{% case .b %}b{% end switch %}`, d{"x": 2, "a": 1, "b": 2}, `b`},
+		{`{% switch call len . %}{% case 0 %}none{% default %}some{% end switch %}`, []int{1}, `some`},
+		{`{% switch "a" %}{% case "a" %}a{% default %}b{% end switch %}`, nil, `a`},
+		{`{% switch . %}{% case 1 %}{% default %}d{% end switch %}`, 1, ``},
+	})
+}
+
+func TestTemplateFailSwitch(t *testing.T) {
+	executeTemplateFails(t, []templateFailCase{
+		{`{% switch call fail %}{% default %}{% end switch %}`, nil},
+		{`{% switch . %}{% case call fail %}{% default %}{% end switch %}`, 1},
+	})
+}
+
+func TestTemplatePassExpressions(t *testing.T) {
+	executeTemplatePasses(t, []templatePassCase{
+		{`{% 1 + 2 * 3 %}`, nil, `7`},
+		{`{% (1 + 2) * 3 %}`, nil, `9`},
+		{`{% 7 / 2 %}|{% 7 % 3 %}|{% 7 / 2.0 %}`, nil, `3|1|3.5`},
+		{`{% .a + 1 %}`, d{"a": 2}, `3`},
+		{`{% .a-1 %}`, d{"a": 2}, `1`},
+		{`{% -.a %}|{% 1 - -.a %}`, d{"a": 2}, `-2|3`},
+		{`{% .a * .b %}`, d{"a": int8(100), "b": int8(2)}, `-56`},
+		{`{% .a - 1 %}`, d{"a": uint(5)}, `4`},
+		{`{% .a - .b %}`, d{"a": uint8(1), "b": uint8(2)}, `255`},
+		{`{% .a / 4 %}`, d{"a": 1.0}, `0.25`},
+		{`{% "a" + "b" %}`, nil, `ab`},
+		{`{% .a + 1 ~ "px" %}`, d{"a": 2}, `3px`},
+		{`{% .a ~ .b %}`, d{"a": "x", "b": 1.5}, `x1.5`},
+		{`{% if .a % 2 %}odd{% else %}even{% end if %}`, d{"a": 3}, `odd`},
+		{`{% switch .a % 3 %}{% case 1 %}one{% end switch %}`, d{"a": 4}, `one`},
+		{`{% range .a as i _ %}{% .i * 2 %}{% end range %}`, d{"a": []int{0, 0, 0}}, `024`},
+		{`{% call lower "A" ~ "B" %}|{% call lower ("A" ~ .b) %}`, d{"b": "B"}, `aB|ab`},
+	})
+}
+
+func TestTemplateFailExpressions(t *testing.T) {
+	executeTemplateFails(t, []templateFailCase{
+		{`{% .a / .b %}`, d{"a": 1, "b": 0}},
+		{`{% .a % .b %}`, d{"a": uint(1), "b": uint(0)}},
+		{`{% .a + .b %}`, d{"a": 1, "b": "x"}},
+		{`{% .a % 2 %}`, d{"a": 1.5}},
+		{`{% .a * .b %}`, d{"a": "x", "b": "y"}},
+		{`{% -.a %}`, d{"a": "x"}},
+		{`{% if .a - "x" %}{% end if %}`, d{"a": 1}},
+		{`{% switch .a / 0.0 %}{% default %}{% end switch %}`, d{"a": 1}},
+	})
+}
+
+func TestTemplatePassMultiline(t *testing.T) {
+	executeTemplatePasses(t, []templatePassCase{
+		{"{% call printf\n\t\"%s-%d\"\n\t.a\n\t.b\n%}", d{"a": "x", "b": 1}, `x-1`},
+		{"{%\r\nif .a\r\n%}yes{% end\nif %}", d{"a": true}, `yes`},
+		{"{% .a +\n   .b %}", d{"a": 1, "b": 2}, `3`},
+	})
+}
+
+func TestTemplatePassRaw(t *testing.T) {
+	executeTemplatePasses(t, []templatePassCase{
+		{`{% raw %}{% .foo %}{# bar #}{% end if %}{% end raw %}`, nil, `{% .foo %}{# bar #}{% end if %}`},
+		{`a{%raw%}{% if %}{%end raw%}b`, nil, `a{% if %}b`},
+		{`{% raw %}{% end raw %}`, nil, ``},
+		{`{% raw %}x{% end raw %}{% raw %}y{% end raw %}`, nil, `xy`},
+		{`{% raw %}{% raw %}{% end raw %}`, nil, `{% raw %}`},
+		{`\{% .foo %}`, nil, `{% .foo %}`},
+		{`a\{# b #}c`, nil, `a{# b #}c`},
+		{`\{% . %}{% . %}`, "x", `{% . %}x`},
+		{`a\b\{`, nil, `a\b\{`},
+	})
+}
+
+func TestTemplateFailNamedArgs(t *testing.T) {
+	executeTemplateFails(t, []templateFailCase{
+		{`{% block b %}{% end block %}{% evoke b x="a" %}`, nil},
+		{`{% block b as x %}{% end block %}{% evoke b y="a" %}`, nil},
 	})
 }
 
@@ -61,6 +307,7 @@ func TestTemplatePassIf(t *testing.T) {
 func TestTemplateFailEvoke(t *testing.T) {
 	executeTemplateFails(t, []templateFailCase{
 		{`{% evoke foo %}`, nil},
+		{`{% block foo %}{% super %}{% end block %}`, nil},
 	})
 }
 
@@ -235,6 +482,47 @@ func TestTemplateFails(t *testing.T) {
 	})
 }
 
+func TestTemplateExecuteContextCancelled(t *testing.T) {
+	tree, err := parse(lex([]byte(`before{% .foo %}after`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	cancel()
+
+	var buf bytes.Buffer
+	if err := tree.ExecuteContext(ctx, &buf, d{"foo": "bar"}); err != gocontext.Canceled {
+		t.Fatalf("Expected %v got %v", gocontext.Canceled, err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("Expected no output got %q", buf.String())
+	}
+}
+
+func TestTemplateExecuteContextRange(t *testing.T) {
+	tree, err := parse(lex([]byte(`{% range . %}{% call tick %}{% end range %}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	defer cancel()
+
+	var ticks int
+	tree.context.funcs["tick"] = reflect.ValueOf(func() int {
+		ticks++
+		cancel()
+		return ticks
+	})
+
+	var buf bytes.Buffer
+	if err := tree.ExecuteContext(ctx, &buf, []int{1, 2, 3}); err != gocontext.Canceled {
+		t.Fatalf("Expected %v got %v", gocontext.Canceled, err)
+	}
+	if ticks != 1 || buf.String() != "1" {
+		t.Fatalf("Expected one iteration got %d: %q", ticks, buf.String())
+	}
+}
+
 func executeTemplateFails(t *testing.T, cases []templateFailCase) {
 	for id, c := range cases {
 		tree, err := parse(lex([]byte(c.template)))
@@ -267,3 +555,36 @@ func executeTemplatePasses(t *testing.T, cases []templatePassCase) {
 		}
 	}
 }
+
+func TestTemplatePassKeywordNames(t *testing.T) {
+	cases := []templatePassCase{
+		{`{% call default .x "n/a" %}|{% call push %}`, d{"x": ""}, `n/a|pushed`},
+		{`{% block default %}d{% end block %}{% evoke default %}`, nil, `dd`},
+		{`{% block stack %}s{% end block %}|{% evoke stack %}`, nil, `s|s`},
+		{`{% range . as _ case %}{% .case %}{% end range %}`, []string{"a", "b"}, `ab`},
+		{`{% capture macro %}m{% end capture %}{% range .a as _ include %}{% .include %}{% .macro %}{% end range %}`, d{"a": []string{"i"}}, `im`},
+		{`{% switch .x %}{% case "default" %}case{% default %}default{% end switch %}`, d{"x": "default"}, `case`},
+	}
+	for id, c := range cases {
+		tree, err := parse(lex([]byte(c.template)))
+		if err != nil {
+			t.Errorf("%d: %v", id, err)
+			continue
+		}
+		tree.context.funcs["default"] = reflect.ValueOf(func(v, def string) string {
+			if v == "" {
+				return def
+			}
+			return v
+		})
+		tree.context.funcs["push"] = reflect.ValueOf(func() string { return "pushed" })
+		var buf bytes.Buffer
+		if err := tree.Execute(&buf, c.context); err != nil {
+			t.Errorf("%d: %v", id, err)
+			continue
+		}
+		if g := buf.String(); g != c.expect {
+			t.Errorf("%d\nGot %q\nExp %q", id, g, c.expect)
+		}
+	}
+}
@@ -0,0 +1,474 @@
+package tmpl
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+//TypeCheck reports every selector in the template that can't be found on a
+//value of the given type, without executing it. It follows the context
+//through with, range, evoke and include, checks the blocks and macros that
+//are evoked and called along the way, and knows the types of range variables
+//and of the arguments passed to blocks. Keys of maps can't be known ahead of
+//time, so any key of a map is allowed, and values of interface types, like
+//the values of a map[string]interface{}, aren't checked any further.
+func (t *Template) TypeCheck(typ reflect.Type) error {
+	if err := t.compiled(); err != nil {
+		return err
+	}
+	t.compileLk.RLock()
+	defer t.compileLk.RUnlock()
+
+	tc := newTypeChecker(t.tree.context)
+	tc.file = t.tree.file
+	tc.stack = typePath{{name: "/", typ: typ}}
+	tc.walk(t.tree.base)
+	return tc.errs.sorted()
+}
+
+// **************
+// * Type Paths *
+// **************
+
+//typeItem is an item of a path whose value is only known by its type. A nil
+//type is a value that can't be known until the template is executed.
+type typeItem struct {
+	name string
+	typ  reflect.Type
+}
+
+type typePath []typeItem
+
+//StringWith returns the same string a path of values with the same names
+//would.
+func (p typePath) StringWith(keys []string) string {
+	pth := make(path, len(p))
+	for i, it := range p {
+		pth[i].name = it.name
+	}
+	return pth.StringWith(keys)
+}
+
+func (p typePath) dup() typePath {
+	return append(typePath(nil), p...)
+}
+
+func (p typePath) lastType() reflect.Type {
+	return p[len(p)-1].typ
+}
+
+//indirectType returns the type values of typ are accessed through, or nil if
+//it can't be known.
+func indirectType(typ reflect.Type) reflect.Type {
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ != nil && typ.Kind() == reflect.Interface {
+		return nil
+	}
+	return typ
+}
+
+// ****************
+// * Type Checker *
+// ****************
+
+//visit is a block, macro or included file checked with the given type on top
+//of the stack and the given types bound to the arguments of the evoke, so
+//that recursion stops once it has been seen.
+type visit struct {
+	node interface{}
+	typ  reflect.Type
+	args string
+}
+
+//typeChecker walks a parse tree keeping track of the types of the values the
+//execution would see.
+type typeChecker struct {
+	findings
+	ctx   *context
+	stack typePath
+	vars  map[string]reflect.Type //the types of set variables by path
+	block *executeBlockValue
+	args  string //the types bound by the evoke being checked
+	seen  map[visit]bool
+}
+
+func newTypeChecker(ctx *context) *typeChecker {
+	return &typeChecker{
+		ctx:  ctx,
+		vars: map[string]reflect.Type{},
+		seen: map[visit]bool{},
+	}
+}
+
+//enter returns if the node should be checked with the current stack, marking
+//it as seen.
+func (tc *typeChecker) enter(node interface{}) bool {
+	v := visit{node, tc.stack.lastType(), tc.args}
+	if tc.seen[v] {
+		return false
+	}
+	tc.seen[v] = true
+	return true
+}
+
+//bind sets the types of variables relative to the current path, returning a
+//function that puts back whatever they hid.
+func (tc *typeChecker) bind(vars map[string]reflect.Type) (restore func()) {
+	type saved struct {
+		typ reflect.Type
+		ex  bool
+	}
+	prev := map[string]saved{}
+	for name, typ := range vars {
+		pth := tc.stack.StringWith([]string{name})
+		old, ex := tc.vars[pth]
+		prev[pth] = saved{old, ex}
+		tc.vars[pth] = typ
+	}
+	return func() {
+		for pth, s := range prev {
+			if s.ex {
+				tc.vars[pth] = s.typ
+			} else {
+				delete(tc.vars, pth)
+			}
+		}
+	}
+}
+
+//access returns the type of the key on a value of typ, where the key would be
+//looked up as a variable at the path pth.
+func (tc *typeChecker) access(pth typePath, typ reflect.Type, key string) (reflect.Type, error) {
+	if vt, ex := tc.vars[pth.StringWith([]string{key})]; ex {
+		return vt, nil
+	}
+	typ = indirectType(typ)
+	if typ == nil {
+		return nil, nil
+	}
+	switch typ.Kind() {
+	case reflect.Map:
+		return typ.Elem(), nil
+	case reflect.Struct:
+		if f, ok := typ.FieldByName(key); ok {
+			return f.Type, nil
+		}
+		return nil, fmt.Errorf("%v has no field %s", typ, key)
+	}
+	return nil, fmt.Errorf("can't get %s from a %v", key, typ.Kind())
+}
+
+//start returns the path the selector starts from, and the keys left to
+//follow from there.
+func (tc *typeChecker) start(s *selectorValue) (typePath, []string, error) {
+	switch {
+	case s.abs:
+		pth := tc.stack[:1:1]
+		if len(s.path) > 0 && s.path[0] == metaKey {
+			return append(pth, typeItem{name: metaKey}), s.path[1:], nil
+		}
+		return pth, s.path, nil
+	case s.pops < 0 || s.pops >= len(tc.stack):
+		return nil, nil, fmt.Errorf("can't pop %d items", s.pops)
+	}
+	n := len(tc.stack) - s.pops
+	return tc.stack[:n:n], s.path, nil
+}
+
+//selectorType returns the type of the value the selector gives, reporting it
+//if it can't be found.
+func (tc *typeChecker) selectorType(s *selectorValue) (typ reflect.Type, ok bool) {
+	pth, keys, err := tc.start(s)
+	if err != nil {
+		tc.errorf(s.line, s.pos, "%v: %v", s.source(), err)
+		return nil, false
+	}
+
+	//like valueAt, variables are looked up relative to where it starts
+	typ = pth.lastType()
+	for _, key := range keys {
+		if typ, err = tc.access(pth, typ, key); err != nil {
+			tc.errorf(s.line, s.pos, "%v: %v", s.source(), err)
+			return nil, false
+		}
+	}
+	return typ, true
+}
+
+//cd changes the stack to the selector like context.cd, reporting it if it
+//can't be found.
+func (tc *typeChecker) cd(s *selectorValue) bool {
+	pth, keys, err := tc.start(s)
+	if err != nil {
+		tc.errorf(s.line, s.pos, "%v: %v", s.source(), err)
+		return false
+	}
+	pth = pth.dup()
+	for _, key := range keys {
+		typ, err := tc.access(pth, pth.lastType(), key)
+		if err != nil {
+			tc.errorf(s.line, s.pos, "%v: %v", s.source(), err)
+			return false
+		}
+		pth = append(pth, typeItem{key, typ})
+	}
+	tc.stack = pth
+	return true
+}
+
+//valueType returns the type of the value, or nil if it can't be known.
+func (tc *typeChecker) valueType(v valueType) (reflect.Type, bool) {
+	switch v := v.(type) {
+	case *selectorValue:
+		return tc.selectorType(v)
+	case callValue:
+		tc.walk(v)
+		if tc.ctx.getMacro(string(v.name)) != nil {
+			return reflect.TypeOf(""), true
+		}
+		if fnc := tc.ctx.getCall(string(v.name)); fnc.IsValid() && fnc.Type().NumOut() > 0 {
+			return fnc.Type().Out(0), true
+		}
+		return nil, true
+	case intValue, floatValue, constantValue:
+		val, _ := v.Value(nil)
+		return reflect.TypeOf(val), true
+	}
+	tc.walk(v)
+	return nil, true
+}
+
+//walk checks everything the executer would do.
+func (tc *typeChecker) walk(ex executer) {
+	switch e := ex.(type) {
+	case executeList:
+		//a capture binds its output for the rest of the list
+		for _, item := range e {
+			if cp, ok := item.(*executeCapture); ok {
+				tc.walk(cp.ex)
+				defer tc.bind(map[string]reflect.Type{cp.ident: reflect.TypeOf("")})()
+				continue
+			}
+			tc.walk(item)
+		}
+
+	case *selectorValue:
+		tc.selectorType(e)
+	case callValue:
+		for _, arg := range e.args {
+			tc.valueType(arg)
+		}
+		if m := tc.ctx.getMacro(string(e.name)); m != nil {
+			tc.walkMacro(m)
+		}
+	case *binaryValue:
+		tc.valueType(e.left)
+		tc.valueType(e.right)
+	case *negValue:
+		tc.valueType(e.val)
+
+	case *executeIf:
+		tc.valueType(e.cond)
+		tc.walk(e.succ)
+		tc.walk(e.fail)
+	case *executeSwitch:
+		tc.valueType(e.val)
+		for _, cs := range e.cases {
+			for _, v := range cs.vals {
+				tc.valueType(v)
+			}
+			tc.walk(cs.ex)
+		}
+		tc.walk(e.def)
+	case *executeWith:
+		defer func(stack typePath) { tc.stack = stack }(tc.stack)
+		if tc.cd(e.ctx) {
+			tc.walk(e.ex)
+		}
+	case *executeRange:
+		tc.walkRange(e)
+	case *executeEvoke:
+		tc.walkEvoke(e)
+	case *executeSuper:
+		if tc.block != nil && tc.block.parent != nil {
+			tc.walkBlock(tc.block.parent)
+		}
+	case *executeInclude:
+		tc.walkInclude(e)
+	case *executePush:
+		tc.walk(e.ex)
+	case *executeCapture:
+		tc.walk(e.ex)
+	}
+}
+
+//walkRange checks the body of a range with its variables bound to the types
+//of the keys and values of what it ranges over.
+func (tc *typeChecker) walkRange(e *executeRange) {
+	typ, ok := tc.valueType(e.iter)
+	if !ok {
+		return
+	}
+
+	//like the execution, the variables hold the values pointed to
+	var kt, vt reflect.Type
+	if typ != nil {
+		switch typ.Kind() {
+		case reflect.Map:
+			kt, vt = indirectType(typ.Key()), indirectType(typ.Elem())
+		case reflect.Slice, reflect.Array:
+			kt, vt = reflect.TypeOf(0), indirectType(typ.Elem())
+		case reflect.Struct:
+			kt = reflect.TypeOf("")
+		case reflect.Interface:
+		default:
+			line, pos := valuePos(e.iter)
+			tc.errorf(line, pos, "can't range over a %v", typ)
+			return
+		}
+	}
+
+	vars := map[string]reflect.Type{}
+	for _, v := range []struct {
+		tok token
+		def string
+		typ reflect.Type
+	}{{e.key, "key", kt}, {e.val, "val", vt}} {
+		switch s := string(v.tok.dat); s {
+		case "_":
+		case "":
+			vars[v.def] = v.typ
+		default:
+			vars[s] = v.typ
+		}
+	}
+	defer tc.bind(vars)()
+	tc.walk(e.ex)
+}
+
+//walkEvoke checks an evoke, and the block it evokes in the context it
+//would be evoked in.
+func (tc *typeChecker) walkEvoke(e *executeEvoke) {
+	if e.name != nil {
+		tc.valueType(e.name)
+	}
+
+	//the body and slots are rendered in the context of the evoke
+	tc.walk(e.body)
+	for _, fill := range e.fills {
+		tc.walk(fill)
+	}
+
+	vars := map[string]reflect.Type{}
+	for _, arg := range e.args {
+		vars[arg.name], _ = tc.valueType(arg.val)
+	}
+	bl := tc.ctx.getBlock(e.ident)
+	if e.name != nil || bl == nil {
+		if e.ctx != nil {
+			tc.selectorType(e.ctx)
+		}
+		return
+	}
+
+	defer func(stack typePath) { tc.stack = stack }(tc.stack)
+	if e.ctx != nil && !tc.cd(e.ctx) {
+		return
+	}
+	for _, param := range bl.params {
+		if _, ex := vars[param]; !ex {
+			vars[param] = nil
+		}
+	}
+	defer tc.bind(vars)()
+	defer func(args string) { tc.args = args }(tc.args)
+	tc.args = argTypes(vars)
+	tc.walkBlock(bl)
+}
+
+//argTypes describes the types bound to the arguments of an evoke, in order
+//of their names.
+func argTypes(vars map[string]reflect.Type) string {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s=%v ", name, vars[name])
+	}
+	return buf.String()
+}
+
+//walkBlock checks a block definition along with the definitions it builds on.
+func (tc *typeChecker) walkBlock(bl *executeBlockValue) {
+	if !tc.enter(bl) {
+		return
+	}
+	if bl.mode != blockReplace && bl.parent != nil {
+		tc.walkBlock(bl.parent)
+	}
+	if bl.ex == nil {
+		return
+	}
+	defer func(block *executeBlockValue, file string) {
+		tc.block, tc.file = block, file
+	}(tc.block, tc.file)
+	tc.block, tc.file = bl, bl.file
+	tc.walk(bl.ex)
+}
+
+//walkMacro checks the body of a macro, whose parameters can be anything.
+func (tc *typeChecker) walkMacro(m *executeMacro) {
+	if m.ex == nil || !tc.enter(m) {
+		return
+	}
+	defer func(stack typePath, file string) {
+		tc.stack, tc.file = stack, file
+	}(tc.stack, tc.file)
+	tc.stack = append(tc.stack.dup(), typeItem{
+		name: "(" + m.ident + ")",
+		typ:  reflect.TypeOf(map[string]interface{}{}),
+	})
+	tc.file = m.file
+	tc.walk(m.ex)
+}
+
+//walkInclude checks an included file in the context it would be rendered in.
+func (tc *typeChecker) walkInclude(e *executeInclude) {
+	tree := tc.ctx.includes[e.abs]
+	if tree == nil || tree.base == nil {
+		return
+	}
+	defer func(stack typePath, file string) {
+		tc.stack, tc.file = stack, file
+	}(tc.stack, tc.file)
+	if e.ctx != nil && !tc.cd(e.ctx) {
+		return
+	}
+	if !tc.enter(tree) {
+		return
+	}
+	tc.file = tree.file
+	tc.walk(tree.base)
+}
+
+//valuePos returns where the value is in its file, if it is known.
+func valuePos(v valueType) (line, pos int) {
+	switch v := v.(type) {
+	case *selectorValue:
+		return v.line, v.pos
+	case callValue:
+		return v.line, v.pos
+	case *binaryValue:
+		return v.line, v.pos
+	case *negValue:
+		return v.line, v.pos
+	}
+	return
+}
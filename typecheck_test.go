@@ -0,0 +1,79 @@
+package tmpl
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type tcUser struct {
+	Name    string
+	Friends []*tcUser
+	Tags    map[string]string
+	Any     interface{}
+}
+
+type tcPage struct {
+	Title string
+	User  *tcUser
+	Users []tcUser
+	Count int
+}
+
+func TestTypeCheck(t *testing.T) {
+	dir := createTestDir(t, []templateFile{
+		{"page.tmpl", `{% .Title %}{% .User.Nmae %}
+{% with .User %}{% .Name %}{% .Age %}{% $.Title %}{% $.Titel %}{% end with %}
+{% range .Users as i u %}{% .u.Name %}{% .i %}{% .u.Bad %}{% end range %}
+{% .User.Tags.anything %}{% .User.Any.whatever.deep %}{% /.@meta.x %}{% .Count.Foo %}
+{% evoke card .User %}{% evoke row item=.User %}{% evoke tree .User %}{% evoke? missing .Nope %}{% evoke row item=.Count %}
+{% capture c %}x{% end capture %}{% .c %}{% .c.Foo %}{% call m .Title %}{% range .Count %}{% end range %}`},
+		{"parts.block", `{% block card %}{% .Name %}{% .Missing %}{% end block %}
+{% block row as item %}{% .item.Name %}{% .item.Nope %}{% end block %}
+{% block tree %}{% .Name %}{% range .Friends as _ f %}{% evoke tree .f %}{% .f.Gone %}{% end range %}{% end block %}
+{% macro m x %}{% .x.anything %}{% $.Title %}{% $.Nope %}{% end macro %}`},
+	})
+	defer os.RemoveAll(dir)
+
+	page, parts := filepath.Join(dir, "page.tmpl"), filepath.Join(dir, "parts.block")
+	err := Parse(page).Blocks(parts).TypeCheck(reflect.TypeOf(tcPage{}))
+	errs, ok := err.(CheckErrors)
+	if !ok {
+		t.Fatalf("Expected CheckErrors got %v", err)
+	}
+
+	expect := []CheckError{
+		{page, 0, 15, ".User.Nmae: tmpl.tcUser has no field Nmae"},
+		{page, 1, 30, ".Age: tmpl.tcUser has no field Age"},
+		{page, 1, 53, "$.Titel: tmpl.tcPage has no field Titel"},
+		{page, 2, 49, ".u.Bad: tmpl.tcUser has no field Bad"},
+		{page, 3, 72, ".Count.Foo: can't get Foo from a int"},
+		{page, 4, 88, ".Nope: tmpl.tcPage has no field Nope"},
+		{page, 5, 44, ".c.Foo: can't get Foo from a string"},
+		{page, 5, 81, "can't range over a int"},
+		{parts, 0, 30, ".Missing: tmpl.tcUser has no field Missing"},
+		{parts, 1, 26, ".item.Name: can't get Name from a int"},
+		{parts, 1, 42, ".item.Nope: tmpl.tcUser has no field Nope"},
+		{parts, 1, 42, ".item.Nope: can't get Nope from a int"},
+		{parts, 2, 76, ".f.Gone: tmpl.tcUser has no field Gone"},
+		{parts, 3, 48, "$.Nope: tmpl.tcPage has no field Nope"},
+	}
+	if len(errs) != len(expect) {
+		t.Errorf("Expected %d errors got %d:\n%v", len(expect), len(errs), errs)
+	}
+	for i := 0; i < len(errs) && i < len(expect); i++ {
+		if *errs[i] != expect[i] {
+			t.Errorf("%d: Expected %v\ngot %v", i, &expect[i], errs[i])
+		}
+	}
+
+	//a template that matches has no errors
+	dir2 := createTestDir(t, []templateFile{
+		{"ok.tmpl", `{% with .User %}{% range .Friends %}{% .val.Name %}{% .key %}{% end range %}{% end with %}{% .Title ~ .Count %}`},
+	})
+	defer os.RemoveAll(dir2)
+	if err := Parse(filepath.Join(dir2, "ok.tmpl")).TypeCheck(reflect.TypeOf(&tcPage{})); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package tmpl
+
+import (
+	gocontext "context"
+	"io"
+	"reflect"
+)
+
+//Typed is a Template that can only be executed with data of type T, so that a
+//handler can't hand a page the wrong value. It is created with NewTyped, which
+//type checks the template against T.
+type Typed[T any] struct {
+	t *Template
+}
+
+//NewTyped wraps the template so it is executed with data of type T, returning
+//the problems found by TypeCheck if the template doesn't match it. The
+//template is only checked once, even in Development mode.
+func NewTyped[T any](t *Template) (*Typed[T], error) {
+	if err := t.TypeCheck(reflect.TypeOf((*T)(nil)).Elem()); err != nil {
+		return nil, err
+	}
+	return &Typed[T]{t}, nil
+}
+
+//MustTyped is like NewTyped but panics if the template doesn't match T.
+func MustTyped[T any](t *Template) *Typed[T] {
+	typed, err := NewTyped[T](t)
+	if err != nil {
+		panic(err)
+	}
+	return typed
+}
+
+//Template returns the wrapped template.
+func (t *Typed[T]) Template() *Template {
+	return t.t
+}
+
+//Execute runs the template with the data like Template.Execute.
+func (t *Typed[T]) Execute(w io.Writer, data T, globs ...string) error {
+	return t.t.Execute(w, data, globs...)
+}
+
+//ExecuteContext runs the template with the data under the given
+//context.Context like Template.ExecuteContext.
+func (t *Typed[T]) ExecuteContext(ctx gocontext.Context, w io.Writer, data T, globs ...string) error {
+	return t.t.ExecuteContext(ctx, w, data, globs...)
+}
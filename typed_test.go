@@ -0,0 +1,42 @@
+package tmpl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTyped(t *testing.T) {
+	dir := createTestDir(t, []templateFile{
+		{"good.tmpl", `{% .Title %}-{% .User.Name %}`},
+		{"bad.tmpl", `{% .Title %}-{% .User.Nmae %}`},
+	})
+	defer os.RemoveAll(dir)
+
+	page, err := NewTyped[tcPage](Parse(filepath.Join(dir, "good.tmpl")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := page.Execute(&buf, tcPage{Title: "users", User: &tcUser{Name: "bob"}}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "users-bob" {
+		t.Errorf("Expected %q got %q", "users-bob", buf.String())
+	}
+
+	bad := Parse(filepath.Join(dir, "bad.tmpl"))
+	if _, err := NewTyped[tcPage](bad); err == nil {
+		t.Error("Expected a type error")
+	} else if _, ok := err.(CheckErrors); !ok {
+		t.Errorf("Expected CheckErrors got %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected MustTyped to panic")
+		}
+	}()
+	MustTyped[tcPage](bad)
+}
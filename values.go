@@ -2,12 +2,22 @@ package tmpl
 
 import (
 	"bytes"
+	gocontext "context"
 	"fmt"
 	"io"
 	"reflect"
 	"strconv"
+	"strings"
 )
 
+//contextType is the type of a context.Context, which is passed automatically
+//as the first argument to functions that ask for it.
+var contextType = reflect.TypeOf((*gocontext.Context)(nil)).Elem()
+
+//errorType is the type of the error interface. A function may return one as
+//its last result to fail the execution.
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
 type valueType interface {
 	executer
 	Value(*context) (interface{}, error)
@@ -41,15 +51,17 @@ func writeValue(w io.Writer, v interface{}) (err error) {
 
 func isValueType(tok token) bool {
 	switch tok.typ {
-	case tokenStartSel, tokenCall, tokenValue, tokenNumeric:
+	case tokenStartSel, tokenCall, tokenValue, tokenNumeric, tokenLParen:
 		return true
+	case tokenOperator:
+		return string(tok.dat) == "-"
 	}
 	return false
 }
 
 func isBasicValueType(tok token) bool {
 	switch tok.typ {
-	case tokenStartSel, tokenValue, tokenNumeric:
+	case tokenStartSel, tokenValue, tokenNumeric, tokenLParen:
 		return true
 	}
 	return false
@@ -71,9 +83,15 @@ func numericToValue(tok token) (v valueType, err error) {
 	return
 }
 
+//consumeValue consumes a value, along with any operators applied to it.
 func consumeValue(p *parser) (valueType, error) {
+	return consumeBinary(p, 1)
+}
+
+//consumeOperand consumes a single value that operators can be applied to.
+func consumeOperand(p *parser) (valueType, error) {
 	switch tok := p.next(); tok.typ {
-	case tokenStartSel, tokenValue, tokenNumeric:
+	case tokenStartSel, tokenValue, tokenNumeric, tokenLParen:
 		p.backup()
 		return consumeBasicValue(p)
 	case tokenCall:
@@ -90,15 +108,34 @@ func consumeBasicValue(p *parser) (valueType, error) {
 		p.backup()
 		return consumeSelector(p)
 	case tokenValue:
-		return constantValue(tok.dat), nil
+		str, err := strconv.Unquote(string(tok.dat))
+		if err != nil {
+			return nil, fmt.Errorf("%d:%d: invalid string %s", tok.line, tok.pos, tok.dat)
+		}
+		return constantValue(str), nil
 	case tokenNumeric:
 		return numericToValue(tok)
+	case tokenLParen:
+		return consumeParenValue(p)
 	default:
 		return nil, fmt.Errorf("Expected a value type got got a %q", tok)
 	}
 	return nil, nil
 }
 
+//consumeParenValue consumes a value grouped in parenthesis, after the left
+//parenthesis has been consumed.
+func consumeParenValue(p *parser) (valueType, error) {
+	val, err := consumeValue(p)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.next(); tok.typ != tokenRParen {
+		return nil, fmt.Errorf("Expected a %q got a %q", tokenRParen, tok)
+	}
+	return val, nil
+}
+
 // ******************
 // * Selector Value *
 // ******************
@@ -107,6 +144,8 @@ type selectorValue struct {
 	pops int
 	abs  bool
 	path []string
+
+	line, pos int
 }
 
 func (s *selectorValue) Value(c *context) (v interface{}, err error) {
@@ -137,9 +176,28 @@ func (s *selectorValue) String() string {
 	return buf.String()
 }
 
+//source returns the selector as it would be written in a template.
+func (s *selectorValue) source() string {
+	var buf bytes.Buffer
+	switch {
+	case s.abs:
+		buf.WriteString("/")
+	case s.pops > 0:
+		buf.WriteString(strings.Repeat("$", s.pops))
+	}
+	if len(s.path) == 0 {
+		buf.WriteString(".")
+	}
+	for _, key := range s.path {
+		fmt.Fprintf(&buf, ".%s", key)
+	}
+	return buf.String()
+}
+
 func consumeSelector(p *parser) (val *selectorValue, err error) {
-	if tok := p.next(); tok.typ != tokenStartSel {
-		return nil, fmt.Errorf("Expected a %q got a %q", tokenStartSel, tok)
+	start := p.next()
+	if start.typ != tokenStartSel {
+		return nil, fmt.Errorf("Expected a %q got a %q", tokenStartSel, start)
 	}
 
 	//at this point the tokenStartSel should be consumed
@@ -147,6 +205,7 @@ func consumeSelector(p *parser) (val *selectorValue, err error) {
 	if err != nil {
 		return
 	}
+	val.line, val.pos = start.line, start.pos
 
 	//consume a push selector
 	if tok := p.next(); tok.typ != tokenPush {
@@ -186,7 +245,7 @@ func consumeSelector(p *parser) (val *selectorValue, err error) {
 func consumeSelectorHeader(p *parser) (val *selectorValue, err error) {
 	switch tok := p.next(); tok.typ {
 	case tokenRoot:
-		return &selectorValue{0, true, nil}, nil
+		return &selectorValue{abs: true}, nil
 	case tokenPush:
 		p.backup()
 		return &selectorValue{}, nil
@@ -195,7 +254,7 @@ func consumeSelectorHeader(p *parser) (val *selectorValue, err error) {
 		for pops = 1; p.next().typ == tokenPop; pops++ {
 		}
 		p.backup()
-		return &selectorValue{pops, false, nil}, nil
+		return &selectorValue{pops: pops}, nil
 	default:
 		return nil, fmt.Errorf("Unexpected %q. Expected a %q, %q, or %q", tok, tokenRoot, tokenPush, tokenPop)
 	}
@@ -208,42 +267,148 @@ func consumeSelectorHeader(p *parser) (val *selectorValue, err error) {
 // **************
 
 type callValue struct {
-	name []byte
-	args []valueType
+	name      []byte
+	args      []valueType
+	line, pos int
+}
+
+//callError is the error for a function that failed during a call. Unlike a
+//selector that can't be found, it always aborts the execution.
+type callError struct {
+	name      string
+	line, pos int
+	err       error
+}
+
+func (c *callError) Error() string {
+	return fmt.Sprintf("%d:%d: call %s: %v", c.line, c.pos, c.name, c.err)
+}
+
+//Unwrap returns the error the function failed with.
+func (c *callError) Unwrap() error {
+	return c.err
+}
+
+//isFatal returns if an error from getting a value should stop the execution,
+//instead of just meaning the value can't be found.
+func isFatal(err error) bool {
+	switch err.(type) {
+	case *callError, *exprError:
+		return true
+	}
+	return false
+}
+
+//checkFunc returns an error if a function has a result shape that can't be
+//used from a template. Functions must return a single value, optionally
+//followed by an error.
+func checkFunc(typ reflect.Type) error {
+	if typ.NumOut() == 0 || (typ.NumOut() == 1 && typ.Out(0) == errorType) {
+		return fmt.Errorf("must return a value")
+	}
+	for i := 0; i < typ.NumOut()-1; i++ {
+		if typ.Out(i) == errorType {
+			return fmt.Errorf("can only return an error as the last result")
+		}
+	}
+	if n := typ.NumOut(); n > 2 || (n == 2 && typ.Out(1) != errorType) {
+		return fmt.Errorf("can only return one value and an optional error")
+	}
+	return nil
+}
+
+//checkArity returns an error if a function can't be called from a template
+//with the given number of arguments.
+func checkArity(typ reflect.Type, args int) error {
+	fixed := typ.NumIn()
+	if fixed > 0 && typ.In(0) == contextType {
+		fixed--
+	}
+	switch {
+	case typ.IsVariadic() && args < fixed-1:
+		return fmt.Errorf("expected at least %d arguments got %d", fixed-1, args)
+	case !typ.IsVariadic() && args != fixed:
+		return fmt.Errorf("expected %d arguments got %d", fixed, args)
+	}
+	return nil
+}
+
+func (s callValue) errorf(format string, args ...interface{}) error {
+	return &callError{string(s.name), s.line, s.pos, fmt.Errorf(format, args...)}
 }
 
 func (s callValue) Value(c *context) (v interface{}, err error) {
 	defer func() {
 		if e := recover(); e != nil {
-			err = fmt.Errorf("call %s: %v", s.name, e)
+			err = s.errorf("%v", e)
 		}
 	}()
 
+	//macros hide the built in functions, but not the attached ones
+	if m := c.getMacro(string(s.name)); m != nil {
+		return m.call(c, s)
+	}
+
 	fnc := c.getCall(string(s.name))
-	var (
-		params []reflect.Value
-		val    interface{}
-	)
-	for _, arg := range s.args {
-		val, err = arg.Value(c)
-		if err != nil {
+	if !fnc.IsValid() {
+		err = s.errorf("function not defined")
+		return
+	}
+	params, err := s.params(c, fnc.Type())
+	if err != nil {
+		return
+	}
+
+	res := fnc.Call(params)
+
+	//a non nil error as the last result fails the call
+	if n := len(res); n > 0 && fnc.Type().Out(n-1) == errorType {
+		if e, _ := res[n-1].Interface().(error); e != nil {
+			err = &callError{string(s.name), s.line, s.pos, e}
 			return
 		}
-		params = append(params, reflect.ValueOf(val))
+		res = res[:n-1]
 	}
 
-	switch res := fnc.Call(params); len(res) {
-	case 0:
-	case 1:
-		v = res[0].Interface()
-	default:
-		var ret []interface{}
-		for _, item := range res {
-			ret = append(ret, item.Interface())
-		}
-		v = ret
+	//functions are checked when attached, so exactly one value is left
+	return res[0].Interface(), nil
+}
+
+//params evaluates the arguments of the call and converts them to the
+//parameter types of a function, handing it the context.Context first if it
+//asks for one.
+func (s callValue) params(c *context, typ reflect.Type) (params []reflect.Value, err error) {
+	in := 0
+	if typ.NumIn() > 0 && typ.In(0) == contextType {
+		params = append(params, reflect.ValueOf(c.goContext()))
+		in = 1
+	}
+
+	//check that we have the right number of arguments
+	if err := checkArity(typ, len(s.args)); err != nil {
+		return nil, s.errorf("%v", err)
 	}
 
+	for i, arg := range s.args {
+		val, err := arg.Value(c)
+		if err != nil {
+			return nil, err
+		}
+
+		//anything past the last fixed parameter fills the variadic slice
+		var ptyp reflect.Type
+		if idx := in + i; typ.IsVariadic() && idx >= typ.NumIn()-1 {
+			ptyp = typ.In(typ.NumIn() - 1).Elem()
+		} else {
+			ptyp = typ.In(idx)
+		}
+
+		rv, err := convertArg(val, ptyp)
+		if err != nil {
+			return nil, s.errorf("argument %d: %v", i+1, err)
+		}
+		params = append(params, rv)
+	}
 	return
 }
 
@@ -283,7 +448,7 @@ func consumeCallValue(p *parser) (valueType, error) {
 		//append it
 		values = append(values, val)
 	}
-	return callValue{name.dat, values}, nil
+	return callValue{name.dat, values, name.line, name.pos}, nil
 }
 
 // ************************
@@ -2,7 +2,12 @@ package tmpl
 
 import (
 	"bytes"
+	gocontext "context"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -12,12 +17,12 @@ func TestValueParseSelector(t *testing.T) {
 		tmpl string
 		sel  *selectorValue
 	}{
-		{`basic`, `{% .foo.bar %}`, &selectorValue{0, false, []string{"foo", "bar"}}},
-		{`rooted`, `{% /.foo.bar %}`, &selectorValue{0, true, []string{"foo", "bar"}}},
-		{`relative`, `{% $$.foo.bar %}`, &selectorValue{2, false, []string{"foo", "bar"}}},
-		{`previous`, `{% $. %}`, &selectorValue{1, false, nil}},
-		{`top`, `{% /. %}`, &selectorValue{0, true, nil}},
-		{`empty`, `{% . %}`, &selectorValue{0, false, nil}},
+		{`basic`, `{% .foo.bar %}`, &selectorValue{0, false, []string{"foo", "bar"}, 0, 3}},
+		{`rooted`, `{% /.foo.bar %}`, &selectorValue{0, true, []string{"foo", "bar"}, 0, 3}},
+		{`relative`, `{% $$.foo.bar %}`, &selectorValue{2, false, []string{"foo", "bar"}, 0, 3}},
+		{`previous`, `{% $. %}`, &selectorValue{1, false, nil, 0, 3}},
+		{`top`, `{% /. %}`, &selectorValue{0, true, nil, 0, 3}},
+		{`empty`, `{% . %}`, &selectorValue{0, false, nil, 0, 3}},
 	}
 
 	for _, c := range cases {
@@ -81,7 +86,7 @@ func TestValueCallPasses(t *testing.T) {
 		{
 			`{% range call foo as _ foo %}{% .foo %}{% end range %}`,
 			`foo`,
-			func() (string, string) { return "foo", "bar" },
+			func() []string { return []string{"foo", "bar"} },
 			nil,
 			"foobar",
 		},
@@ -95,13 +100,247 @@ func TestValueCallPasses(t *testing.T) {
 	})
 }
 
+func TestValueCallConversions(t *testing.T) {
+	type name string
+	executePassingCallTests(t, []callTest{
+		{
+			`{% call foo .a %}`,
+			`foo`,
+			func(x int8) int8 { return x },
+			d{"a": int64(100)},
+			"100",
+		},
+		{
+			`{% call foo .a %}`,
+			`foo`,
+			func(x float64) float64 { return x / 2 },
+			d{"a": 3},
+			"1.5",
+		},
+		{
+			`{% call foo .a %}`,
+			`foo`,
+			func(x uint) uint { return x },
+			d{"a": 2.0},
+			"2",
+		},
+		{
+			`{% call foo .a %}`,
+			`foo`,
+			func(x []int) int { return len(x) },
+			d{"a": nil},
+			"0",
+		},
+		{
+			`{% call foo .a %}`,
+			`foo`,
+			func(x fmt.Stringer) string { return x.String() },
+			d{"a": &s{}},
+			"foo",
+		},
+		{
+			`{% call foo .a %}`,
+			`foo`,
+			func(x string) string { return x },
+			d{"a": name("zeebo")},
+			"zeebo",
+		},
+		{
+			`{% call sum %}`,
+			`sum`,
+			func(xs ...int) int { return len(xs) },
+			nil,
+			"0",
+		},
+		{
+			`{% call sum .a .b .c %}`,
+			`sum`,
+			func(xs ...int) (t int) {
+				for _, x := range xs {
+					t += x
+				}
+				return
+			},
+			d{"a": 1, "b": int64(2), "c": uint8(3)},
+			"6",
+		},
+		{
+			`{% call join .a .b .c %}`,
+			`join`,
+			func(sep string, xs ...string) string { return strings.Join(xs, sep) },
+			d{"a": "-", "b": "x", "c": "y"},
+			"x-y",
+		},
+	})
+}
+
+func TestValueCallConversionFailures(t *testing.T) {
+	cases := []struct {
+		tmpl string
+		fn   interface{}
+		ctx  interface{}
+		msg  string
+	}{
+		{`{% call foo .a %}`, func(x int8) int8 { return x }, d{"a": 300}, "overflows"},
+		{`{% call foo .a %}`, func(x uint) uint { return x }, d{"a": -1}, "overflows"},
+		{`{% call foo .a %}`, func(x int) int { return x }, d{"a": 1.5}, "truncated"},
+		{`{% call foo .a %}`, func(x int) int { return x }, d{"a": "1"}, "can't use string as int"},
+		{`{% call foo %}`, func(x int) int { return x }, nil, "expected 1 arguments got 0"},
+		{`{% call foo .a .a %}`, func(x int) int { return x }, d{"a": 1}, "expected 1 arguments got 2"},
+		{`{% call foo %}`, func(x int, xs ...int) int { return x }, nil, "expected at least 1 arguments got 0"},
+		{`{% call foo .a .b %}`, func(xs ...int) int { return 0 }, d{"a": 1, "b": "x"}, "argument 2"},
+		{`{% call bar %}`, func() int { return 0 }, nil, "function not defined"},
+	}
+	for id, c := range cases {
+		tree, err := parse(lex([]byte(c.tmpl)))
+		if err != nil {
+			t.Errorf("%d: error parsing: %s", id, err)
+			continue
+		}
+		tree.context.funcs["foo"] = reflect.ValueOf(c.fn)
+
+		err = tree.Execute(ioutil.Discard, c.ctx)
+		if err == nil || !strings.Contains(err.Error(), c.msg) {
+			t.Errorf("%d: Expected an error containing %q got %v", id, c.msg, err)
+		}
+	}
+}
+
+func TestValueCallErrorResult(t *testing.T) {
+	executePassingCallTests(t, []callTest{
+		{
+			`{% call foo %}`,
+			`foo`,
+			func() (string, error) { return "foo", nil },
+			nil,
+			"foo",
+		},
+		{
+			`{% if call foo %}yes{% end if %}`,
+			`foo`,
+			func() (bool, error) { return true, nil },
+			nil,
+			"yes",
+		},
+	})
+
+	failure := errors.New("failure")
+	for id, tmpl := range []string{
+		`{% call foo %}`,
+		`{% if call foo %}yes{% else %}no{% end if %}`,
+		`{% range call foo %}{% end range %}`,
+	} {
+		tree, err := parse(lex([]byte(tmpl)))
+		if err != nil {
+			t.Errorf("%d: error parsing: %s", id, err)
+			continue
+		}
+		tree.context.funcs["foo"] = reflect.ValueOf(func() (string, error) { return "", failure })
+
+		err = tree.Execute(ioutil.Discard, nil)
+		if !errors.Is(err, failure) {
+			t.Errorf("%d: Expected %v got %v", id, failure, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), "call foo") {
+			t.Errorf("%d: error doesn't name the function: %v", id, err)
+		}
+	}
+}
+
+func TestValueCallRejectsResultShapes(t *testing.T) {
+	cases := []interface{}{
+		func() {},
+		func() error { return nil },
+		func() (error, string) { return nil, "" },
+		func() (string, error, error) { return "", nil, nil },
+		func() (string, int) { return "", 0 },
+		func() (string, int, error) { return "", 0, nil },
+	}
+	for id, fn := range cases {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%d: Call didn't panic on %T", id, fn)
+				}
+			}()
+			Parse("base.tmpl").Call("foo", fn)
+		}()
+	}
+}
+
+func TestValueCallGlobalFuncs(t *testing.T) {
+	Funcs(map[string]interface{}{
+		"globalName":  func() string { return "global" },
+		"globalShade": func() string { return "global" },
+		"upper":       func(x string) string { return "global " + x },
+	})
+	defer func() {
+		globalFuncsLk.Lock()
+		defer globalFuncsLk.Unlock()
+		delete(globalFuncs, "globalName")
+		delete(globalFuncs, "globalShade")
+		delete(globalFuncs, "upper")
+	}()
+
+	tree, err := parse(lex([]byte(`{% call globalName %}-{% call globalShade %}-{% call upper "x" %}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree.context.funcs["globalShade"] = reflect.ValueOf(func() string { return "local" })
+
+	var buf bytes.Buffer
+	if err := tree.Execute(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if exp, got := "global-local-global x", buf.String(); got != exp {
+		t.Fatalf("\nExp %q\nGot %q", exp, got)
+	}
+}
+
+func TestValueCallFuncsHideMacros(t *testing.T) {
+	tree, err := parse(lex([]byte(`{% macro name %}macro{% end macro %}{% macro upper s %}macro{% end macro %}{% call name %}-{% call upper "x" %}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree.context.funcs["name"] = reflect.ValueOf(func() string { return "func" })
+
+	var buf bytes.Buffer
+	if err := tree.Execute(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if exp, got := "func-macro", buf.String(); got != exp {
+		t.Fatalf("\nExp %q\nGot %q", exp, got)
+	}
+}
+
+func TestValueCallContext(t *testing.T) {
+	type key struct{}
+	tree, err := parse(lex([]byte(`{% call user .greeting %}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree.context.funcs["user"] = reflect.ValueOf(func(ctx gocontext.Context, greeting string) string {
+		return greeting + " " + ctx.Value(key{}).(string)
+	})
+
+	ctx := gocontext.WithValue(gocontext.Background(), key{}, "zeebo")
+	var buf bytes.Buffer
+	if err := tree.ExecuteContext(ctx, &buf, d{"greeting": "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "hello zeebo" {
+		t.Fatalf("Expected %q got %q", "hello zeebo", got)
+	}
+}
+
 func TestValueBadSelectors(t *testing.T) {
 	cases := []struct {
 		name string
 		tmpl string
 	}{
 		{`pop inside`, `{% .foo$.bar %}`},
-		{`root inside`, `{% .foo/.bar %}`},
+		{`root inside`, `{% .foo./bar %}`},
 		{`double push`, `{% .foo..bar %}`},
 		{`pop after root`, `{% /$.foo %}`},
 		{`root after pop`, `{% $/.foo %}`},